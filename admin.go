@@ -1,19 +1,44 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"sync/atomic"
+	"time"
 
+	"github.com/circuit-shell/http-server-go/internal/auth"
 	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/circuit-shell/http-server-go/internal/mailer"
+	"github.com/circuit-shell/http-server-go/internal/oidc"
+	"github.com/circuit-shell/http-server-go/internal/storage"
+	"github.com/google/uuid"
 )
 
+const ADMIN_API_KEY_HEADER = "X-Admin-API-Key"
+
 type apiConfig struct {
-	fileserverHits atomic.Int32
-	dbQueries      *database.Queries
-	platform       string
-	serverSecret   string
+	fileserverHits  atomic.Int32
+	db              *sql.DB
+	dbQueries       *database.Queries
+	keyring         *auth.Keyring
+	passwords       *auth.PasswordPolicy
+	adminAPIKeyHash string
+	platform        string
+	mailer          mailer.Mailer
+	appBaseURL      string
+	oidcProviders   map[string]*oidc.Provider
+	blobs           storage.BlobStore
+	mediaQueued     chan struct{}
+	fanOutQueued    chan struct{}
+	editWindows     editWindows
+
+	verifiedEmailRequired bool
 }
 
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
@@ -23,6 +48,61 @@ func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 	})
 }
 
+// requireAdmin lets a request through when it carries either an access
+// token with the admin scope or the configured admin API key. Every request
+// that gets past authentication is written to the audit log.
+func (cfg *apiConfig) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	withToken := cfg.requireAuth(auth.ScopeAdmin)(cfg.auditAdmin(next))
+	return func(w http.ResponseWriter, r *http.Request) {
+		apiKey := r.Header.Get(ADMIN_API_KEY_HEADER)
+		if apiKey == "" {
+			withToken(w, r)
+			return
+		}
+
+		if cfg.adminAPIKeyHash == "" ||
+			subtle.ConstantTimeCompare([]byte(auth.HashToken(apiKey)), []byte(cfg.adminAPIKeyHash)) != 1 {
+			respondWithError(w, http.StatusUnauthorized, "Invalid admin API key", nil)
+			return
+		}
+		cfg.auditAdmin(next)(w, r)
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	rec.status = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (cfg *apiConfig) auditAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		entry := database.CreateAdminAuditEntryParams{
+			ActorType:  "api_key",
+			Action:     r.Method + " " + r.URL.Path,
+			StatusCode: int32(rec.status),
+			RemoteAddr: r.RemoteAddr,
+		}
+		if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+			entry.ActorType = "user"
+			entry.ActorUserID = uuid.NullUUID{UUID: principal.UserID, Valid: true}
+		}
+
+		// the request may already be cancelled, the audit entry must still be written
+		err := cfg.dbQueries.CreateAdminAuditEntry(context.WithoutCancel(r.Context()), entry)
+		if err != nil {
+			log.Printf("Error writing admin audit entry for %s: %s", entry.Action, err)
+		}
+	}
+}
+
 func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "text/html")
 	m := fmt.Sprintf(`
@@ -41,6 +121,8 @@ func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handlerMetricsReset wipes the users table, so besides an admin it needs
+// PLATFORM=dev.
 func (cfg *apiConfig) handlerMetricsReset(w http.ResponseWriter, r *http.Request) {
 	if cfg.platform != "dev" {
 		w.WriteHeader(http.StatusForbidden)
@@ -57,3 +139,82 @@ func (cfg *apiConfig) handlerMetricsReset(w http.ResponseWriter, r *http.Request
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Hits reset to 0 and database reset to initial state."))
 }
+
+type auditEntry struct {
+	ID          uuid.UUID  `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ActorType   string     `json:"actor_type"`
+	ActorUserID *uuid.UUID `json:"actor_user_id"`
+	Action      string     `json:"action"`
+	StatusCode  int32      `json:"status_code"`
+	RemoteAddr  string     `json:"remote_addr"`
+}
+
+func (cfg *apiConfig) handlerAdminAudit(w http.ResponseWriter, r *http.Request) {
+	pageSize, err := parsePageSize(r.URL.Query().Get("limit"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	entries, err := cfg.dbQueries.GetAdminAuditEntries(r.Context(), int32(pageSize))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading audit log", err)
+		return
+	}
+
+	formatted := []auditEntry{}
+	for _, entry := range entries {
+		var actorUserID *uuid.UUID
+		if entry.ActorUserID.Valid {
+			actorUserID = &entry.ActorUserID.UUID
+		}
+		formatted = append(formatted, auditEntry{
+			ID:          entry.ID,
+			CreatedAt:   entry.CreatedAt,
+			ActorType:   entry.ActorType,
+			ActorUserID: actorUserID,
+			Action:      entry.Action,
+			StatusCode:  entry.StatusCode,
+			RemoteAddr:  entry.RemoteAddr,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, formatted)
+}
+
+func (cfg *apiConfig) handlerAdminUpdateRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	type parameters struct {
+		Role auth.Role `json:"role"`
+	}
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Role != auth.RoleUser && params.Role != auth.RoleModerator && params.Role != auth.RoleAdmin {
+		respondWithError(w, http.StatusBadRequest, "role must be user, moderator or admin", nil)
+		return
+	}
+
+	user, err := cfg.dbQueries.UpdateUserRole(r.Context(), database.UpdateUserRoleParams{
+		ID:   userID,
+		Role: string(params.Role),
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, userFromDatabase(user))
+}
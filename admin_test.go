@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandlerMetricsResetOutsideDev(t *testing.T) {
+	tests := []struct {
+		name     string
+		platform string
+	}{
+		{name: "unset", platform: ""},
+		{name: "production", platform: "production"},
+		{name: "case matters", platform: "DEV"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// no database: reaching it would panic instead of returning 403
+			cfg := &apiConfig{platform: tt.platform}
+			cfg.fileserverHits.Store(7)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/admin/reset", nil)
+			cfg.handlerMetricsReset(w, r)
+
+			if w.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+			}
+			if got := cfg.fileserverHits.Load(); got != 7 {
+				t.Errorf("fileserverHits = %d, want it left at 7", got)
+			}
+		})
+	}
+}
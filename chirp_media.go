@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+const MAX_CHIRP_MEDIA = 4
+const MAX_ALT_TEXT_LENGTH = 1000
+
+var errInvalidChirpMedia = errors.New("invalid media")
+
+type chirpMediaInput struct {
+	ID      uuid.UUID `json:"id"`
+	AltText string    `json:"alt_text"`
+}
+
+// ChirpMedia is media as attached to a chirp, with its alt text.
+type ChirpMedia struct {
+	Media
+	AltText string `json:"alt_text"`
+}
+
+// checkChirpMedia makes sure userID may attach every one of media, and
+// trims the alt texts. Media that is still processing is fine; it shows up
+// once it is ready.
+func (cfg *apiConfig) checkChirpMedia(ctx context.Context, userID uuid.UUID, media []chirpMediaInput) error {
+	if len(media) > MAX_CHIRP_MEDIA {
+		return fmt.Errorf("%w: a chirp can have at most %d media", errInvalidChirpMedia, MAX_CHIRP_MEDIA)
+	}
+
+	seen := []uuid.UUID{}
+	for i := range media {
+		media[i].AltText = strings.TrimSpace(media[i].AltText)
+		if utf8.RuneCountInString(media[i].AltText) > MAX_ALT_TEXT_LENGTH {
+			return fmt.Errorf("%w: alt_text must be at most %d characters", errInvalidChirpMedia, MAX_ALT_TEXT_LENGTH)
+		}
+		if slices.Contains(seen, media[i].ID) {
+			return fmt.Errorf("%w: media %s is attached twice", errInvalidChirpMedia, media[i].ID)
+		}
+		seen = append(seen, media[i].ID)
+
+		stored, err := cfg.dbQueries.GetMedia(ctx, media[i].ID)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && stored.UserID != userID) {
+			return fmt.Errorf("%w: couldn't find media %s", errInvalidChirpMedia, media[i].ID)
+		}
+		if err != nil {
+			return err
+		}
+		if stored.Status == "failed" {
+			return fmt.Errorf("%w: media %s couldn't be processed: %s", errInvalidChirpMedia, media[i].ID, stored.Error)
+		}
+	}
+	return nil
+}
+
+// embedMedia fills in the media of every chirp, with two queries however
+// many chirps there are.
+func (cfg *apiConfig) embedMedia(ctx context.Context, chirps []Chirp) error {
+	chirpIDs := make([]uuid.UUID, len(chirps))
+	for i, chirp := range chirps {
+		chirpIDs[i] = chirp.ID
+	}
+	if len(chirpIDs) == 0 {
+		return nil
+	}
+
+	attached, err := cfg.dbQueries.GetChirpMedia(ctx, chirpIDs)
+	if err != nil {
+		return err
+	}
+	if len(attached) == 0 {
+		return nil
+	}
+
+	mediaIDs := make([]uuid.UUID, len(attached))
+	for i, row := range attached {
+		mediaIDs[i] = row.Medium.ID
+	}
+	renditions, err := cfg.dbQueries.GetMediaRenditionsByMediaIDs(ctx, mediaIDs)
+	if err != nil {
+		return err
+	}
+	renditionsByMedia := map[uuid.UUID][]database.MediaRendition{}
+	for _, rendition := range renditions {
+		renditionsByMedia[rendition.MediaID] = append(renditionsByMedia[rendition.MediaID], rendition)
+	}
+
+	mediaByChirp := map[uuid.UUID][]ChirpMedia{}
+	for _, row := range attached {
+		mediaByChirp[row.ChirpID] = append(mediaByChirp[row.ChirpID], ChirpMedia{
+			Media:   cfg.mediaFromDatabase(row.Medium, renditionsByMedia[row.Medium.ID]),
+			AltText: row.AltText,
+		})
+	}
+	for i := range chirps {
+		if media, ok := mediaByChirp[chirps[i].ID]; ok {
+			chirps[i].Media = media
+		}
+	}
+	return nil
+}
+
+// collectMedia deletes those of mediaIDs no chirp uses any more and that
+// aren't an avatar. It returns the blobs that may have to go too, which
+// the caller passes to deleteMediaBlobs once the transaction has
+// committed.
+func (cfg *apiConfig) collectMedia(ctx context.Context, q *database.Queries, mediaIDs []uuid.UUID) ([]string, error) {
+	if len(mediaIDs) == 0 {
+		return nil, nil
+	}
+	renditions, err := q.GetMediaRenditionsByMediaIDs(ctx, mediaIDs)
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := q.DeleteUnreferencedMedia(ctx, database.DeleteUnreferencedMediaParams{
+		Ids: mediaIDs,
+		// avatar_url is a rendition's mediaURL
+		UrlPrefix: cfg.mediaURL(""),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for _, media := range deleted {
+		// the original only exists until processing is done
+		if media.Status == "processing" {
+			keys = append(keys, media.BlobKey)
+		}
+		for _, rendition := range renditions {
+			if rendition.MediaID == media.ID {
+				keys = append(keys, rendition.BlobKey)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// deleteMediaBlobs removes the blobs no media or avatar refers to any
+// more. A blob that couldn't be deleted is only wasted space, so errors
+// are logged.
+func (cfg *apiConfig) deleteMediaBlobs(ctx context.Context, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	unreferenced, err := cfg.dbQueries.GetUnreferencedBlobKeys(ctx, database.GetUnreferencedBlobKeysParams{
+		BlobKeys:  keys,
+		UrlPrefix: cfg.mediaURL(""),
+	})
+	if err != nil {
+		log.Printf("Error finding unused media blobs: %s", err)
+		return
+	}
+	for _, key := range unreferenced {
+		err := cfg.blobs.Delete(ctx, key)
+		if err != nil {
+			log.Printf("Error deleting media blob %s: %s", key, err)
+		}
+	}
+}
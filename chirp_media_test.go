@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+// mediaConnector is a database that answers every query with the media
+// row of the id it's given, owned by owner and ready unless it's failed.
+type mediaConnector struct {
+	owner  uuid.UUID
+	failed uuid.UUID
+}
+
+func (c mediaConnector) Connect(context.Context) (driver.Conn, error) { return mediaConn(c), nil }
+func (c mediaConnector) Driver() driver.Driver                        { return nil }
+
+type mediaConn mediaConnector
+
+func (c mediaConn) Prepare(string) (driver.Stmt, error) { return mediaStmt(c), nil }
+func (c mediaConn) Close() error                        { return nil }
+func (c mediaConn) Begin() (driver.Tx, error)           { return nil, errors.New("no transactions") }
+
+type mediaStmt mediaConn
+
+func (s mediaStmt) Close() error                               { return nil }
+func (s mediaStmt) NumInput() int                              { return -1 }
+func (s mediaStmt) Exec([]driver.Value) (driver.Result, error) { return nil, errors.New("read only") }
+func (s mediaStmt) Query(args []driver.Value) (driver.Rows, error) {
+	id, err := uuid.Parse(args[0].(string))
+	if err != nil {
+		return nil, err
+	}
+	media := database.Medium{ID: id, UserID: s.owner, CreatedAt: time.Now(), Status: "ready"}
+	if id == s.failed {
+		media.Status = "failed"
+		media.Error = "not an image"
+	}
+	return &mediaRows{media: media}, nil
+}
+
+type mediaRows struct {
+	media database.Medium
+	done  bool
+}
+
+func (r *mediaRows) Columns() []string {
+	return []string{"id", "user_id", "blob_key", "content_type", "size_bytes", "created_at", "status",
+		"width", "height", "error", "claimed_at", "processed_at"}
+}
+
+func (r *mediaRows) Close() error { return nil }
+
+func (r *mediaRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	m := r.media
+	copy(dest, []driver.Value{m.ID.String(), m.UserID.String(), m.BlobKey, m.ContentType, m.SizeBytes, m.CreatedAt,
+		m.Status, int64(m.Width), int64(m.Height), m.Error, nil, nil})
+	return nil
+}
+
+func TestCheckChirpMedia(t *testing.T) {
+	userID := uuid.New()
+	failed := uuid.New()
+	db := sql.OpenDB(mediaConnector{owner: userID, failed: failed})
+	defer db.Close()
+	cfg := &apiConfig{db: db, dbQueries: database.New(db)}
+
+	// media returns n distinct media of the user, without alt text
+	media := func(n int) []chirpMediaInput {
+		inputs := make([]chirpMediaInput, n)
+		for i := range inputs {
+			inputs[i].ID = uuid.New()
+		}
+		return inputs
+	}
+	duplicate := media(2)
+	duplicate[1].ID = duplicate[0].ID
+
+	tests := []struct {
+		name    string
+		userID  uuid.UUID
+		media   []chirpMediaInput
+		wantErr bool
+	}{
+		{name: "none", userID: userID, media: nil},
+		{name: "at the limit", userID: userID, media: media(MAX_CHIRP_MEDIA)},
+		{name: "over the limit", userID: userID, media: media(MAX_CHIRP_MEDIA + 1), wantErr: true},
+		{name: "attached twice", userID: userID, media: duplicate, wantErr: true},
+		{
+			name:   "longest alt text",
+			userID: userID,
+			media:  []chirpMediaInput{{ID: uuid.New(), AltText: strings.Repeat("é", MAX_ALT_TEXT_LENGTH)}},
+		},
+		{
+			name:   "spaces around the alt text don't count",
+			userID: userID,
+			media:  []chirpMediaInput{{ID: uuid.New(), AltText: "  " + strings.Repeat("a", MAX_ALT_TEXT_LENGTH) + "\n"}},
+		},
+		{
+			name:    "alt text too long",
+			userID:  userID,
+			media:   []chirpMediaInput{{ID: uuid.New(), AltText: strings.Repeat("é", MAX_ALT_TEXT_LENGTH+1)}},
+			wantErr: true,
+		},
+		{name: "someone else's media", userID: uuid.New(), media: media(1), wantErr: true},
+		{name: "failed media", userID: userID, media: []chirpMediaInput{{ID: failed}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := cfg.checkChirpMedia(context.Background(), tt.userID, tt.media)
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidChirpMedia) {
+					t.Errorf("checkChirpMedia() error = %v, want errInvalidChirpMedia", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("checkChirpMedia() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckChirpMediaTrimsAltText(t *testing.T) {
+	userID := uuid.New()
+	db := sql.OpenDB(mediaConnector{owner: userID})
+	defer db.Close()
+	cfg := &apiConfig{db: db, dbQueries: database.New(db)}
+
+	media := []chirpMediaInput{{ID: uuid.New(), AltText: "  a cat on a keyboard\n"}}
+	err := cfg.checkChirpMedia(context.Background(), userID, media)
+	if err != nil {
+		t.Fatalf("checkChirpMedia() error = %v", err)
+	}
+	if media[0].AltText != "a cat on a keyboard" {
+		t.Errorf("alt text = %q, want it trimmed", media[0].AltText)
+	}
+}
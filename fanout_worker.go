@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+const FANOUT_WORKERS = 2
+
+// workers wake up as soon as a chirp is posted, and look for chirps left
+// behind by a crashed worker every so often
+const FANOUT_POLL_INTERVAL = 30 * time.Second
+
+// startFanOutWorkers copies new chirps into their followers' timelines in
+// the background until ctx is done.
+func (cfg *apiConfig) startFanOutWorkers(ctx context.Context) {
+	for i := 0; i < FANOUT_WORKERS; i++ {
+		go cfg.runFanOutWorker(ctx)
+	}
+}
+
+func (cfg *apiConfig) runFanOutWorker(ctx context.Context) {
+	for {
+		chirpID, err := cfg.dbQueries.ClaimFanOut(ctx)
+		if err == nil {
+			err = cfg.fanOut(ctx, chirpID)
+			if err != nil {
+				log.Printf("Error fanning out chirp %s: %s", chirpID, err)
+			}
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error claiming chirp to fan out: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-cfg.fanOutQueued:
+		case <-time.After(FANOUT_POLL_INTERVAL):
+		}
+	}
+}
+
+// notifyFanOutQueued wakes up an idle worker, if there is one.
+func (cfg *apiConfig) notifyFanOutQueued() {
+	select {
+	case cfg.fanOutQueued <- struct{}{}:
+	default:
+	}
+}
+
+// fanOut copies a chirp into the timeline of everyone following its
+// author, unless the author has too many followers for that. Either way
+// the chirp leaves the queue; on an error it stays claimed and is retried
+// once the claim runs out.
+func (cfg *apiConfig) fanOut(ctx context.Context, chirpID uuid.UUID) error {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	// deleting the chirp takes it off the queue as well
+	chirp, err := qtx.GetChirpForUpdate(ctx, chirpID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	followerCount, err := qtx.GetFollowerCountForShare(ctx, chirp.UserID)
+	if err != nil {
+		return err
+	}
+
+	if followerCount <= FANOUT_MAX_FOLLOWERS && !chirp.DeletedAt.Valid {
+		err = qtx.FanOutChirp(ctx, database.FanOutChirpParams{
+			ChirpID:   chirp.ID,
+			CreatedAt: chirp.CreatedAt,
+			AuthorID:  chirp.UserID,
+		})
+		if err != nil {
+			return err
+		}
+		err = qtx.MarkChirpFannedOut(ctx, chirp.ID)
+		if err != nil {
+			return err
+		}
+	}
+	err = qtx.FinishFanOut(ctx, chirp.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
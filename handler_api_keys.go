@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/circuit-shell/http-server-go/internal/auth"
+	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+const DEFAULT_API_KEY_EXPIRES_IN_DAYS = 90
+const MAX_API_KEY_EXPIRES_IN_DAYS = 365
+const MAX_API_KEY_NAME_LENGTH = 100
+
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Hint       string     `json:"hint"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func apiKeyFromDatabase(key database.ApiKey) APIKey {
+	var lastUsedAt *time.Time
+	if key.LastUsedAt.Valid {
+		lastUsedAt = &key.LastUsedAt.Time
+	}
+	return APIKey{
+		ID:         key.ID,
+		Name:       key.Name,
+		Hint:       key.KeyHint,
+		Scopes:     key.Scopes,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: lastUsedAt,
+	}
+}
+
+func (cfg *apiConfig) handlerCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays *int     `json:"expires_in_days"`
+	}
+	type response struct {
+		APIKey
+		Key string `json:"key"`
+	}
+
+	principal := principalFromRequest(r)
+	// a leaked key must not be able to mint more keys
+	if principal.FromKey {
+		respondWithError(w, http.StatusForbidden, "API keys can't create API keys", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" || len(params.Name) > MAX_API_KEY_NAME_LENGTH {
+		respondWithError(w, http.StatusBadRequest, "name is required and must be at most 100 characters", nil)
+		return
+	}
+	if len(params.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "at least one scope is required", nil)
+		return
+	}
+	err = auth.CheckScopes(principal.Role, params.Scopes)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	expiresInDays := DEFAULT_API_KEY_EXPIRES_IN_DAYS
+	if params.ExpiresInDays != nil {
+		expiresInDays = *params.ExpiresInDays
+	}
+	if expiresInDays < 1 || expiresInDays > MAX_API_KEY_EXPIRES_IN_DAYS {
+		respondWithError(w, http.StatusBadRequest, "expires_in_days must be between 1 and 365", nil)
+		return
+	}
+
+	key, err := auth.MakeAPIKey()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error generating API key", err)
+		return
+	}
+
+	apiKey, err := cfg.dbQueries.CreateAPIKey(r.Context(), database.CreateAPIKeyParams{
+		UserID:    principal.UserID,
+		Name:      params.Name,
+		KeyHash:   auth.HashToken(key),
+		KeyHint:   auth.APIKeyHint(key),
+		Scopes:    params.Scopes,
+		ExpiresAt: time.Now().UTC().AddDate(0, 0, expiresInDays),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error saving API key", err)
+		return
+	}
+
+	// the key itself is only ever shown here
+	respondWithJSON(w, http.StatusCreated, response{
+		APIKey: apiKeyFromDatabase(apiKey),
+		Key:    key,
+	})
+}
+
+func (cfg *apiConfig) handlerListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := cfg.dbQueries.GetAPIKeysByUser(r.Context(), principalFromRequest(r).UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API keys", err)
+		return
+	}
+
+	formatted := []APIKey{}
+	for _, key := range keys {
+		formatted = append(formatted, apiKeyFromDatabase(key))
+	}
+	respondWithJSON(w, http.StatusOK, formatted)
+}
+
+func (cfg *apiConfig) handlerRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyID, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid API key ID", err)
+		return
+	}
+
+	revoked, err := cfg.dbQueries.RevokeAPIKey(r.Context(), database.RevokeAPIKeyParams{
+		ID:     keyID,
+		UserID: principalFromRequest(r).UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke API key", err)
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "Couldn't find API key", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -13,37 +17,66 @@ import (
 const MAX_CHIRP_LENGTH = 140
 
 type chirpInput struct {
-	Body   string    `json:"body"`
-	UserID uuid.UUID `json:"user_id"`
+	Body      string            `json:"body"`
+	UserID    uuid.UUID         `json:"user_id"`
+	Media     []chirpMediaInput `json:"media"`
+	InReplyTo *uuid.UUID        `json:"in_reply_to"`
+	RechirpOf *uuid.UUID        `json:"rechirp_of"`
+	QuoteOf   *uuid.UUID        `json:"quote_of"`
 }
 
 type Chirp struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Body      string    `json:"body"`
-	UserID    uuid.UUID `json:"user_id"`
+	ID        uuid.UUID    `json:"id"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	Body      string       `json:"body"`
+	UserID    uuid.UUID    `json:"user_id"`
+	InReplyTo *uuid.UUID   `json:"in_reply_to"`
+	RootID    *uuid.UUID   `json:"root_id"`
+	RechirpOf *uuid.UUID   `json:"rechirp_of"`
+	QuoteOf   *uuid.UUID   `json:"quote_of"`
+	Rechirped *Chirp       `json:"rechirped,omitempty"`
+	Quoted    *Chirp       `json:"quoted,omitempty"`
+	Deleted   bool         `json:"deleted,omitempty"`
+	LikeCount int32        `json:"like_count"`
+	LikedByMe bool         `json:"liked_by_me"`
+	Author    *Author      `json:"author,omitempty"`
+	Media     []ChirpMedia `json:"media"`
 }
 
-func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, r *http.Request) {
-	// get the token from the request
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Error getting token", err)
-		return
+func chirpFromDatabase(chirp database.Chirp) Chirp {
+	response := Chirp{
+		ID:        chirp.ID,
+		CreatedAt: chirp.CreatedAt,
+		UpdatedAt: chirp.UpdatedAt,
+		Body:      chirp.Body,
+		UserID:    chirp.UserID,
+		Deleted:   chirp.DeletedAt.Valid,
+		LikeCount: chirp.LikeCount,
+		Media:     []ChirpMedia{},
 	}
-
-	// validate the token
-	userID, err := auth.ValidateJWT(token, cfg.serverSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Error validating token", err)
-		return
+	if chirp.InReplyTo.Valid {
+		response.InReplyTo = &chirp.InReplyTo.UUID
+	}
+	if chirp.RootID.Valid {
+		response.RootID = &chirp.RootID.UUID
+	}
+	if chirp.RechirpOf.Valid {
+		response.RechirpOf = &chirp.RechirpOf.UUID
+	}
+	if chirp.QuoteOf.Valid {
+		response.QuoteOf = &chirp.QuoteOf.UUID
 	}
+	return response
+}
+
+func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, r *http.Request) {
+	userID := principalFromRequest(r).UserID
 
 	decoder := json.NewDecoder(r.Body)
 	params := chirpInput{}
 
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error posting chirp, decoding params", err)
 		return
@@ -52,49 +85,287 @@ func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusBadRequest, "Chirp is too long", nil)
 		return
 	}
+	if params.RechirpOf != nil && (params.Body != "" || len(params.Media) > 0 || params.InReplyTo != nil || params.QuoteOf != nil) {
+		respondWithError(w, http.StatusBadRequest, "A rechirp can't have a body, media, in_reply_to or quote_of", nil)
+		return
+	}
+
+	err = cfg.checkChirpMedia(r.Context(), userID, params.Media)
+	if errors.Is(err, errInvalidChirpMedia) {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error checking media", err)
+		return
+	}
+
+	// a reply joins the thread of the chirp it replies to
+	var inReplyTo, rootID, rechirpOf, quoteOf uuid.NullUUID
+	if params.InReplyTo != nil {
+		parent, err := cfg.referencedChirp(r.Context(), *params.InReplyTo)
+		if errors.Is(err, errInvalidReference) {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error reading chirp", err)
+			return
+		}
+		inReplyTo = uuid.NullUUID{UUID: parent.ID, Valid: true}
+		rootID = parent.RootID
+		if !rootID.Valid {
+			rootID = inReplyTo
+		}
+	}
+	if params.RechirpOf != nil {
+		original, err := cfg.referencedChirp(r.Context(), *params.RechirpOf)
+		if errors.Is(err, errInvalidReference) {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error reading chirp", err)
+			return
+		}
+		rechirpOf = uuid.NullUUID{UUID: original.ID, Valid: true}
+	}
+	if params.QuoteOf != nil {
+		original, err := cfg.referencedChirp(r.Context(), *params.QuoteOf)
+		if errors.Is(err, errInvalidReference) {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error reading chirp", err)
+			return
+		}
+		quoteOf = uuid.NullUUID{UUID: original.ID, Valid: true}
+	}
 
 	cleaned_body := censorProfanity(params.Body)
 
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting transaction", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
 	// Add error handling for database operation
-	chirp, err := cfg.dbQueries.CreateChirp(r.Context(), database.CreateChirpParams{
-		Body:   cleaned_body,
-		UserID: userID,
+	chirp, err := qtx.CreateChirp(r.Context(), database.CreateChirpParams{
+		Body:      cleaned_body,
+		UserID:    userID,
+		InReplyTo: inReplyTo,
+		RootID:    rootID,
+		RechirpOf: rechirpOf,
+		QuoteOf:   quoteOf,
 	})
+	// deleted since it was looked up above
+	if isForeignKeyViolation(err, "chirps_in_reply_to_fkey") ||
+		isForeignKeyViolation(err, "chirps_rechirp_of_fkey") ||
+		isForeignKeyViolation(err, "chirps_quote_of_fkey") {
+		respondWithError(w, http.StatusBadRequest, "The chirp referred to was deleted", err)
+		return
+	}
+	if isConstraintViolation(err, "chirps_rechirp_of_idx") {
+		respondWithError(w, http.StatusConflict, "You already rechirped this chirp", err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error creating chirp", err)
 		return
 	}
+	// followers' timelines look the chirp up until a worker has copied it
+	// into them
+	err = qtx.QueueFanOut(r.Context(), chirp.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error adding chirp to timelines", err)
+		return
+	}
+	for i, media := range params.Media {
+		err = qtx.AttachChirpMedia(r.Context(), database.AttachChirpMediaParams{
+			ChirpID:  chirp.ID,
+			MediaID:  media.ID,
+			Position: int16(i),
+			AltText:  media.AltText,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error attaching media", err)
+			return
+		}
+	}
 
-	respondWithJSON(w, http.StatusCreated, Chirp{
-		ID:        chirp.ID,
-		CreatedAt: chirp.CreatedAt,
-		UpdatedAt: chirp.CreatedAt,
-		Body:      chirp.Body,
-		UserID:    chirp.UserID,
-	})
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating chirp", err)
+		return
+	}
+	cfg.notifyFanOutQueued()
+
+	chirps := []Chirp{chirpFromDatabase(chirp)}
+	err = cfg.embedMedia(r.Context(), chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading media", err)
+		return
+	}
+	err = cfg.embedOriginals(r.Context(), userID, false, chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading original chirps", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, chirps[0])
 
 }
 
+// chirpsPage is a page of chirps with the cursor of the next one.
+type chirpsPage struct {
+	Chirps     []Chirp `json:"chirps"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
 func (cfg *apiConfig) handlerReadChirps(w http.ResponseWriter, r *http.Request) {
-	chirps, err := cfg.dbQueries.GetChirps(r.Context())
+	query := r.URL.Query()
+	params := database.GetChirpsPageAscParams{}
+
+	if authorIDStr := query.Get("author_id"); authorIDStr != "" {
+		authorID, err := uuid.Parse(authorIDStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid author_id", err)
+			return
+		}
+		params.AuthorID = uuid.NullUUID{UUID: authorID, Valid: true}
+	}
+
+	sortOrder := query.Get("sort")
+	if sortOrder == "" {
+		sortOrder = "asc"
+	}
+	if sortOrder != "asc" && sortOrder != "desc" {
+		respondWithError(w, http.StatusBadRequest, "sort must be asc or desc", nil)
+		return
+	}
+
+	pageSize, err := parsePageSize(query.Get("limit"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	withAuthors, err := parseExpandAuthor(query.Get("expand"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	// fetch one extra row to find out whether there is a next page
+	params.PageSize = int32(pageSize + 1)
+
+	// a cursor only continues the listing it came from
+	listing := sortOrder + "|"
+	if params.AuthorID.Valid {
+		listing += params.AuthorID.UUID.String()
+	}
+	if cursorStr := query.Get("cursor"); cursorStr != "" {
+		cursor, err := decodeListCursor(cursorStr, listing)
+		if errors.Is(err, errCursorMismatch) {
+			respondWithError(w, http.StatusBadRequest, "cursor doesn't match sort and author_id", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+			return
+		}
+		params.CursorCreatedAt = sql.NullTime{Time: cursor.CreatedAt, Valid: true}
+		params.CursorID = uuid.NullUUID{UUID: cursor.ID, Valid: true}
+	}
+
+	// clients from before paging expect every chirp as a bare array;
+	// asking for a page with ?limit= or ?cursor= gets the page with
+	// next_cursor instead
+	paged := query.Has("limit") || query.Has("cursor")
+
+	var chirps []database.Chirp
+	if paged {
+		chirps, err = cfg.getChirpsPage(r.Context(), sortOrder, params)
+	} else {
+		chirps, err = cfg.getAllChirps(r.Context(), sortOrder, params)
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error reading chirps", err)
 		return
 	}
 
-	formatted_chirps := []Chirp{}
+	page := chirpsPage{
+		Chirps: []Chirp{},
+	}
+	if paged && len(chirps) > pageSize {
+		chirps = chirps[:pageSize]
+		last := chirps[len(chirps)-1]
+		page.NextCursor = encodeListCursor(last.CreatedAt, last.ID, listing)
+	}
 
 	for _, chirp := range chirps {
-		formatted_chirps = append(formatted_chirps, Chirp{
-			ID:        chirp.ID,
-			CreatedAt: chirp.CreatedAt,
-			UpdatedAt: chirp.UpdatedAt,
-			Body:      chirp.Body,
-			UserID:    chirp.UserID,
-		})
+		page.Chirps = append(page.Chirps, chirpFromDatabase(chirp))
+	}
+	err = cfg.embedMedia(r.Context(), page.Chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading media", err)
+		return
+	}
+	err = cfg.embedLikes(r.Context(), principalFromRequest(r).UserID, page.Chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading likes", err)
+		return
+	}
+	if withAuthors {
+		err = cfg.embedAuthors(r.Context(), page.Chirps)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error reading authors", err)
+			return
+		}
+	}
+	err = cfg.embedOriginals(r.Context(), principalFromRequest(r).UserID, withAuthors, page.Chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading original chirps", err)
+		return
+	}
+
+	if !paged {
+		respondWithJSON(w, http.StatusOK, page.Chirps)
+		return
+	}
+	if page.NextCursor != "" {
+		next := r.URL.Query()
+		next.Set("cursor", page.NextCursor)
+		w.Header().Set("Link", fmt.Sprintf(`<%s%s?%s>; rel="next"`, cfg.appBaseURL, r.URL.Path, next.Encode()))
+	}
+	respondWithJSON(w, http.StatusOK, page)
+}
 
+// getChirpsPage reads the page of chirps params asks for, in sortOrder.
+func (cfg *apiConfig) getChirpsPage(ctx context.Context, sortOrder string, params database.GetChirpsPageAscParams) ([]database.Chirp, error) {
+	if sortOrder == "desc" {
+		return cfg.dbQueries.GetChirpsPageDesc(ctx, database.GetChirpsPageDescParams(params))
+	}
+	return cfg.dbQueries.GetChirpsPageAsc(ctx, params)
+}
+
+// getAllChirps reads every chirp params matches, a page at a time.
+func (cfg *apiConfig) getAllChirps(ctx context.Context, sortOrder string, params database.GetChirpsPageAscParams) ([]database.Chirp, error) {
+	params.PageSize = MAX_PAGE_SIZE
+	chirps := []database.Chirp{}
+	for {
+		page, err := cfg.getChirpsPage(ctx, sortOrder, params)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, page...)
+		if len(page) < MAX_PAGE_SIZE {
+			return chirps, nil
+		}
+		last := page[len(page)-1]
+		params.CursorCreatedAt = sql.NullTime{Time: last.CreatedAt, Valid: true}
+		params.CursorID = uuid.NullUUID{UUID: last.ID, Valid: true}
 	}
-	respondWithJSON(w, http.StatusOK, formatted_chirps)
 }
 
 func (cfg *apiConfig) handlerReadChirpById(w http.ResponseWriter, r *http.Request) {
@@ -109,19 +380,41 @@ func (cfg *apiConfig) handlerReadChirpById(w http.ResponseWriter, r *http.Reques
 		respondWithError(w, http.StatusInternalServerError, "Error need a chirp ID", err)
 		return
 	}
+	withAuthor, err := parseExpandAuthor(r.URL.Query().Get("expand"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
 	chirp, err := cfg.dbQueries.GetChirpsByID(r.Context(), userID)
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "Error reading chirp", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, Chirp{
-		ID:        chirp.ID,
-		CreatedAt: chirp.CreatedAt,
-		UpdatedAt: chirp.UpdatedAt,
-		Body:      chirp.Body,
-		UserID:    chirp.UserID,
-	})
+	chirps := []Chirp{chirpFromDatabase(chirp)}
+	err = cfg.embedMedia(r.Context(), chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading media", err)
+		return
+	}
+	err = cfg.embedLikes(r.Context(), principalFromRequest(r).UserID, chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading likes", err)
+		return
+	}
+	if withAuthor {
+		err = cfg.embedAuthors(r.Context(), chirps)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error reading author", err)
+			return
+		}
+	}
+	err = cfg.embedOriginals(r.Context(), principalFromRequest(r).UserID, withAuthor, chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading original chirps", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chirps[0])
 }
 
 func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request) {
@@ -132,32 +425,65 @@ func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-	userID, err := auth.ValidateJWT(token, cfg.serverSecret)
+	principal := principalFromRequest(r)
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Error starting transaction", err)
 		return
 	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
 
-	dbChirp, err := cfg.dbQueries.GetChirpsByID(r.Context(), chirpID)
+	// locked, so no reply can sneak in before the chirp is gone
+	dbChirp, err := qtx.GetChirpForUpdate(r.Context(), chirpID)
+	if err == nil && dbChirp.DeletedAt.Valid {
+		err = sql.ErrNoRows
+	}
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "Couldn't get chirp", err)
 		return
 	}
-	if dbChirp.UserID != userID {
+	// authors delete their own chirps, moderators can delete anyone's
+	if dbChirp.UserID != principal.UserID && !principal.HasScope(auth.ScopeChirpsModerate) {
 		respondWithError(w, http.StatusForbidden, "You can't delete this chirp", err)
 		return
 	}
 
-	err = cfg.dbQueries.DeleteChirp(r.Context(), chirpID)
+	attached, err := qtx.GetChirpMedia(r.Context(), []uuid.UUID{chirpID})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get media", err)
+		return
+	}
+	referenced, err := qtx.ChirpIsReferenced(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp", err)
+		return
+	}
+	if referenced {
+		err = tombstoneChirp(r.Context(), qtx, chirpID)
+	} else {
+		err = qtx.DeleteChirp(r.Context(), chirpID)
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp", err)
 		return
 	}
+	mediaIDs := make([]uuid.UUID, len(attached))
+	for i, row := range attached {
+		mediaIDs[i] = row.Medium.ID
+	}
+	blobKeys, err := cfg.collectMedia(r.Context(), qtx, mediaIDs)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete media", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp", err)
+		return
+	}
+	cfg.deleteMediaBlobs(r.Context(), blobKeys)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerLikeChirp likes a chirp for the caller. Liking it again changes
+// nothing.
+func (cfg *apiConfig) handlerLikeChirp(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	chirp, err := cfg.dbQueries.GetChirpsByID(r.Context(), chirpID)
+	if err == nil && chirp.DeletedAt.Valid {
+		err = sql.ErrNoRows
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp", err)
+		return
+	}
+	// liking a rechirp likes the chirp it shares
+	if chirp.RechirpOf.Valid {
+		chirpID = chirp.RechirpOf.UUID
+	}
+
+	err = cfg.dbQueries.LikeChirp(r.Context(), database.LikeChirpParams{
+		UserID:  principalFromRequest(r).UserID,
+		ChirpID: chirpID,
+	})
+	// deleted since it was looked up above
+	if isForeignKeyViolation(err, "chirp_likes_chirp_id_fkey") {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't like chirp", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerUnlikeChirp takes back the caller's like, if there is one.
+func (cfg *apiConfig) handlerUnlikeChirp(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	chirp, err := cfg.dbQueries.GetChirpsByID(r.Context(), chirpID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp", err)
+		return
+	}
+	if err == nil && chirp.RechirpOf.Valid {
+		chirpID = chirp.RechirpOf.UUID
+	}
+
+	err = cfg.dbQueries.UnlikeChirp(r.Context(), database.UnlikeChirpParams{
+		UserID:  principalFromRequest(r).UserID,
+		ChirpID: chirpID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unlike chirp", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerUserLikes lists the chirps a user has liked, most recently liked
+// first.
+func (cfg *apiConfig) handlerUserLikes(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	query := r.URL.Query()
+	pageSize, err := parsePageSize(query.Get("limit"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	withAuthors, err := parseExpandAuthor(query.Get("expand"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	params := database.GetLikedChirpsPageParams{
+		UserID: userID,
+		// fetch one extra row to find out whether there is a next page
+		PageSize: int32(pageSize + 1),
+	}
+	if cursorStr := query.Get("cursor"); cursorStr != "" {
+		cursor, err := decodeCursor(cursorStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+			return
+		}
+		params.CursorCreatedAt = sql.NullTime{Time: cursor.CreatedAt, Valid: true}
+		params.CursorID = uuid.NullUUID{UUID: cursor.ID, Valid: true}
+	}
+
+	_, err = cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "User not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	liked, err := cfg.dbQueries.GetLikedChirpsPage(r.Context(), params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading likes", err)
+		return
+	}
+
+	page := chirpsPage{
+		Chirps: []Chirp{},
+	}
+	if len(liked) > pageSize {
+		liked = liked[:pageSize]
+		// keyed on when the chirp was liked, not when it was posted
+		last := liked[len(liked)-1]
+		page.NextCursor = encodeCursor(last.LikedAt, last.Chirp.ID)
+	}
+
+	for _, row := range liked {
+		page.Chirps = append(page.Chirps, chirpFromDatabase(row.Chirp))
+	}
+	err = cfg.embedMedia(r.Context(), page.Chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading media", err)
+		return
+	}
+	err = cfg.embedLikes(r.Context(), principalFromRequest(r).UserID, page.Chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading likes", err)
+		return
+	}
+	if withAuthors {
+		err = cfg.embedAuthors(r.Context(), page.Chirps)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error reading authors", err)
+			return
+		}
+	}
+	err = cfg.embedOriginals(r.Context(), principalFromRequest(r).UserID, withAuthors, page.Chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading original chirps", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, page)
+}
+
+// embedLikes sets liked_by_me on the chirps viewerID has liked. Anonymous
+// callers, with a nil viewerID, haven't liked anything.
+func (cfg *apiConfig) embedLikes(ctx context.Context, viewerID uuid.UUID, chirps []Chirp) error {
+	if viewerID == uuid.Nil || len(chirps) == 0 {
+		return nil
+	}
+	chirpIDs := make([]uuid.UUID, len(chirps))
+	for i, chirp := range chirps {
+		chirpIDs[i] = chirp.ID
+	}
+
+	liked, err := cfg.dbQueries.GetLikedChirpIDs(ctx, database.GetLikedChirpIDsParams{
+		UserID:   viewerID,
+		ChirpIds: chirpIDs,
+	})
+	if err != nil {
+		return err
+	}
+	for i := range chirps {
+		chirps[i].LikedByMe = slices.Contains(liked, chirps[i].ID)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+// editWindows is how long after posting a chirp its author may edit it.
+// Zero means there is no limit.
+type editWindows struct {
+	Default time.Duration
+	Red     time.Duration
+}
+
+func (e editWindows) forUser(user database.User) time.Duration {
+	if user.IsChirpyRed {
+		return e.Red
+	}
+	return e.Default
+}
+
+// loadEditWindows reads CHIRP_EDIT_WINDOW and CHIRP_EDIT_WINDOW_RED, the
+// latter for Chirpy Red members, as durations like "15m". Red members get
+// the default window when only that one is set.
+func loadEditWindows(window, redWindow string) (editWindows, error) {
+	windows := editWindows{}
+	if window != "" {
+		value, err := time.ParseDuration(window)
+		if err != nil || value < 0 {
+			return editWindows{}, fmt.Errorf("CHIRP_EDIT_WINDOW must be a duration like 15m: %q", window)
+		}
+		windows.Default = value
+	}
+	windows.Red = windows.Default
+	if redWindow != "" {
+		value, err := time.ParseDuration(redWindow)
+		if err != nil || value < 0 {
+			return editWindows{}, fmt.Errorf("CHIRP_EDIT_WINDOW_RED must be a duration like 1h: %q", redWindow)
+		}
+		windows.Red = value
+	}
+	return windows, nil
+}
+
+// handlerUpdateChirp lets the author replace the body of a chirp. The
+// body it replaces is kept as a revision.
+func (cfg *apiConfig) handlerUpdateChirp(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+	principal := principalFromRequest(r)
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if len(params.Body) > MAX_CHIRP_LENGTH {
+		respondWithError(w, http.StatusBadRequest, "Chirp is too long", nil)
+		return
+	}
+	cleaned_body := censorProfanity(params.Body)
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting transaction", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	// locked, so concurrent edits each keep the body they replaced
+	chirp, err := qtx.GetChirpForUpdate(r.Context(), chirpID)
+	if err == nil && chirp.DeletedAt.Valid {
+		err = sql.ErrNoRows
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp", err)
+		return
+	}
+	// unlike deleting, moderators can't put words in someone else's mouth
+	if chirp.UserID != principal.UserID {
+		respondWithError(w, http.StatusForbidden, "You can't edit this chirp", nil)
+		return
+	}
+	if chirp.RechirpOf.Valid {
+		respondWithError(w, http.StatusBadRequest, "Rechirps have no body to edit", nil)
+		return
+	}
+
+	user, err := qtx.GetUserByID(r.Context(), principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	window := cfg.editWindows.forUser(user)
+	if window > 0 && time.Since(chirp.CreatedAt) > window {
+		respondWithError(w, http.StatusForbidden, fmt.Sprintf("Chirps can only be edited for %s after posting", window), nil)
+		return
+	}
+
+	if cleaned_body != chirp.Body {
+		err = qtx.CreateChirpRevision(r.Context(), database.CreateChirpRevisionParams{
+			ChirpID:   chirp.ID,
+			Body:      chirp.Body,
+			CreatedAt: chirp.UpdatedAt,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save revision", err)
+			return
+		}
+		chirp, err = qtx.UpdateChirpBody(r.Context(), database.UpdateChirpBodyParams{
+			ID:   chirp.ID,
+			Body: cleaned_body,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp", err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp", err)
+		return
+	}
+
+	chirps := []Chirp{chirpFromDatabase(chirp)}
+	err = cfg.embedMedia(r.Context(), chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading media", err)
+		return
+	}
+	err = cfg.embedLikes(r.Context(), principalFromRequest(r).UserID, chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading likes", err)
+		return
+	}
+	err = cfg.embedOriginals(r.Context(), principalFromRequest(r).UserID, false, chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading original chirps", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chirps[0])
+}
+
+type ChirpRevision struct {
+	ID         uuid.UUID `json:"id"`
+	Body       string    `json:"body"`
+	CreatedAt  time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// handlerChirpRevisions lists the earlier bodies of a chirp, newest first.
+func (cfg *apiConfig) handlerChirpRevisions(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	_, err = cfg.dbQueries.GetChirpsByID(r.Context(), chirpID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp", err)
+		return
+	}
+
+	revisions, err := cfg.dbQueries.GetChirpRevisions(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get revisions", err)
+		return
+	}
+
+	response := []ChirpRevision{}
+	for _, revision := range revisions {
+		response = append(response, ChirpRevision{
+			ID:         revision.ID,
+			Body:       revision.Body,
+			CreatedAt:  revision.CreatedAt,
+			ReplacedAt: revision.ReplacedAt,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/circuit-shell/http-server-go/internal/database"
+)
+
+func TestLoadEditWindows(t *testing.T) {
+	tests := []struct {
+		name      string
+		window    string
+		redWindow string
+		want      editWindows
+		wantErr   bool
+	}{
+		{name: "unset", want: editWindows{}},
+		{name: "default only", window: "15m", want: editWindows{Default: 15 * time.Minute, Red: 15 * time.Minute}},
+		{name: "both", window: "15m", redWindow: "1h", want: editWindows{Default: 15 * time.Minute, Red: time.Hour}},
+		{name: "red only", redWindow: "1h", want: editWindows{Red: time.Hour}},
+		{name: "zero red window", window: "15m", redWindow: "0s", want: editWindows{Default: 15 * time.Minute}},
+		{name: "not a duration", window: "15", wantErr: true},
+		{name: "negative", window: "-1m", wantErr: true},
+		{name: "bad red window", window: "15m", redWindow: "forever", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadEditWindows(tt.window, tt.redWindow)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadEditWindows() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("loadEditWindows() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEditWindowsForUser(t *testing.T) {
+	windows := editWindows{Default: 15 * time.Minute, Red: time.Hour}
+
+	tests := []struct {
+		name string
+		user database.User
+		want time.Duration
+	}{
+		{name: "regular user", user: database.User{}, want: 15 * time.Minute},
+		{name: "Chirpy Red member", user: database.User{IsChirpyRed: true}, want: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := windows.forUser(tt.user); got != tt.want {
+				t.Errorf("forUser() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
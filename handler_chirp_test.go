@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/circuit-shell/http-server-go/internal/auth"
+	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestCreateRechirpRejectsContent(t *testing.T) {
+	original := uuid.New().String()
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "body", body: `{"rechirp_of": "` + original + `", "body": "me too"}`},
+		{name: "media", body: `{"rechirp_of": "` + original + `", "media": [{"id": "` + uuid.New().String() + `"}]}`},
+		{name: "in_reply_to", body: `{"rechirp_of": "` + original + `", "in_reply_to": "` + uuid.New().String() + `"}`},
+		{name: "quote_of", body: `{"rechirp_of": "` + original + `", "quote_of": "` + original + `"}`},
+		{name: "everything", body: `{"rechirp_of": "` + original + `", "body": "me too", "quote_of": "` + original + `"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// no database: reaching it would panic instead of returning 400
+			cfg := &apiConfig{}
+
+			r := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(tt.body))
+			r = r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{UserID: uuid.New()}))
+			w := httptest.NewRecorder()
+			cfg.handlerCreateChirp(w, r)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d; body %s", w.Code, http.StatusBadRequest, w.Body)
+			}
+		})
+	}
+}
+
+func TestReadChirpsRejectsCursorOfAnotherListing(t *testing.T) {
+	authorID := uuid.New().String()
+	cursor := encodeListCursor(time.Now().UTC(), uuid.New(), "desc|"+authorID)
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{name: "other sort", query: "sort=asc&author_id=" + authorID},
+		{name: "other author", query: "sort=desc&author_id=" + uuid.New().String()},
+		{name: "no author", query: "sort=desc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// no database: reaching it would panic instead of returning 400
+			cfg := &apiConfig{}
+
+			r := httptest.NewRequest(http.MethodGet, "/api/chirps?"+tt.query+"&cursor="+cursor, nil)
+			w := httptest.NewRecorder()
+			cfg.handlerReadChirps(w, r)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d; body %s", w.Code, http.StatusBadRequest, w.Body)
+			}
+		})
+	}
+}
+
+func TestReadChirpsWithoutPagingListsEveryChirp(t *testing.T) {
+	db := testDB(t)
+	q := database.New(db)
+	cfg := &apiConfig{db: db, dbQueries: q}
+
+	author := createTestUser(t, q, "author")
+	want := MAX_PAGE_SIZE + DEFAULT_PAGE_SIZE + 1
+	for i := 0; i < want; i++ {
+		_, err := q.CreateChirp(context.Background(), database.CreateChirpParams{Body: "hello", UserID: author.ID})
+		if err != nil {
+			t.Fatalf("creating chirp: %v", err)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/chirps", nil)
+	w := httptest.NewRecorder()
+	cfg.handlerReadChirps(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body %s", w.Code, http.StatusOK, w.Body)
+	}
+	chirps := []Chirp{}
+	err := json.NewDecoder(w.Body).Decode(&chirps)
+	if err != nil {
+		t.Fatalf("decoding chirps: %v", err)
+	}
+	if len(chirps) != want {
+		t.Errorf("got %d chirps, want %d", len(chirps), want)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+const DEFAULT_THREAD_DEPTH = 5
+const MAX_THREAD_DEPTH = 20
+
+// deeper threads are cut off at the top; the oldest ancestor returned can
+// be asked for its own thread
+const MAX_THREAD_ANCESTORS = 50
+
+// ThreadNode is a reply with the replies to it that are on the same page.
+type ThreadNode struct {
+	Chirp
+	Replies []*ThreadNode `json:"replies"`
+}
+
+type Thread struct {
+	Ancestors     []Chirp       `json:"ancestors"`
+	MoreAncestors bool          `json:"more_ancestors"`
+	Chirp         Chirp         `json:"chirp"`
+	Replies       []*ThreadNode `json:"replies"`
+	NextCursor    string        `json:"next_cursor,omitempty"`
+}
+
+// tombstoneChirp deletes everything a chirp says but keeps the chirp
+// itself, so replies and quotes still have something to point at.
+func tombstoneChirp(ctx context.Context, q *database.Queries, chirpID uuid.UUID) error {
+	err := q.TombstoneChirp(ctx, chirpID)
+	if err != nil {
+		return err
+	}
+	// rechirps have nothing left to share
+	err = q.DeleteRechirps(ctx, uuid.NullUUID{UUID: chirpID, Valid: true})
+	if err != nil {
+		return err
+	}
+	err = q.DetachChirpMedia(ctx, chirpID)
+	if err != nil {
+		return err
+	}
+	return q.DeleteChirpRevisions(ctx, chirpID)
+}
+
+func parseThreadDepth(depth string) (int, error) {
+	if depth == "" {
+		return DEFAULT_THREAD_DEPTH, nil
+	}
+
+	value, err := strconv.Atoi(depth)
+	if err != nil || value < 1 || value > MAX_THREAD_DEPTH {
+		return 0, errors.New("depth must be between 1 and " + strconv.Itoa(MAX_THREAD_DEPTH))
+	}
+	return value, nil
+}
+
+// handlerChirpThread returns the chirps a chirp replies to, root first,
+// and a page of the replies below it as a tree. Replies on later pages
+// hang off chirps on earlier ones; their in_reply_to says which.
+func (cfg *apiConfig) handlerChirpThread(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	query := r.URL.Query()
+	depth, err := parseThreadDepth(query.Get("depth"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	pageSize, err := parsePageSize(query.Get("limit"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	withAuthors, err := parseExpandAuthor(query.Get("expand"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	params := database.GetChirpRepliesParams{
+		ChirpID:  chirpID,
+		MaxDepth: int32(depth),
+		// fetch one extra row to find out whether there is a next page
+		PageSize: int32(pageSize + 1),
+	}
+	if cursorStr := query.Get("cursor"); cursorStr != "" {
+		cursor, err := decodeCursor(cursorStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+			return
+		}
+		params.CursorCreatedAt = sql.NullTime{Time: cursor.CreatedAt, Valid: true}
+		params.CursorID = uuid.NullUUID{UUID: cursor.ID, Valid: true}
+	}
+
+	// tombstones have threads too
+	chirp, err := cfg.dbQueries.GetChirpsByID(r.Context(), chirpID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp", err)
+		return
+	}
+	ancestors, err := cfg.dbQueries.GetChirpAncestors(r.Context(), database.GetChirpAncestorsParams{
+		ChirpID:      chirpID,
+		MaxAncestors: MAX_THREAD_ANCESTORS + 1,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading thread", err)
+		return
+	}
+	replies, err := cfg.dbQueries.GetChirpReplies(r.Context(), params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading thread", err)
+		return
+	}
+
+	thread := Thread{
+		Ancestors: []Chirp{},
+		Replies:   []*ThreadNode{},
+	}
+	if len(ancestors) > MAX_THREAD_ANCESTORS {
+		ancestors = ancestors[:MAX_THREAD_ANCESTORS]
+		thread.MoreAncestors = true
+	}
+	if len(replies) > pageSize {
+		replies = replies[:pageSize]
+		last := replies[len(replies)-1]
+		thread.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+
+	// everything is embedded in one go: the chirp, then its ancestors
+	// root first, then the replies
+	chirps := []Chirp{chirpFromDatabase(chirp)}
+	for _, ancestor := range slices.Backward(ancestors) {
+		chirps = append(chirps, chirpFromDatabase(ancestor))
+	}
+	for _, reply := range replies {
+		chirps = append(chirps, chirpFromDatabase(reply))
+	}
+	err = cfg.embedMedia(r.Context(), chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading media", err)
+		return
+	}
+	err = cfg.embedLikes(r.Context(), principalFromRequest(r).UserID, chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading likes", err)
+		return
+	}
+	if withAuthors {
+		err = cfg.embedAuthors(r.Context(), chirps)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error reading authors", err)
+			return
+		}
+	}
+	err = cfg.embedOriginals(r.Context(), principalFromRequest(r).UserID, withAuthors, chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading original chirps", err)
+		return
+	}
+
+	thread.Chirp = chirps[0]
+	thread.Ancestors = append(thread.Ancestors, chirps[1:1+len(ancestors)]...)
+	thread.Replies = buildReplyTree(chirps[1+len(ancestors):])
+	respondWithJSON(w, http.StatusOK, thread)
+}
+
+// buildReplyTree nests replies under the ones they reply to. Replies whose
+// parent isn't among them end up at the top.
+func buildReplyTree(replies []Chirp) []*ThreadNode {
+	nodes := map[uuid.UUID]*ThreadNode{}
+	for _, reply := range replies {
+		nodes[reply.ID] = &ThreadNode{Chirp: reply, Replies: []*ThreadNode{}}
+	}
+
+	tree := []*ThreadNode{}
+	for _, reply := range replies {
+		node := nodes[reply.ID]
+		if reply.InReplyTo != nil {
+			if parent, ok := nodes[*reply.InReplyTo]; ok {
+				parent.Replies = append(parent.Replies, node)
+				continue
+			}
+		}
+		tree = append(tree, node)
+	}
+	return tree
+}
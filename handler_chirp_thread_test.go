@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestParseThreadDepth(t *testing.T) {
+	tests := []struct {
+		name    string
+		depth   string
+		want    int
+		wantErr bool
+	}{
+		{name: "default", depth: "", want: DEFAULT_THREAD_DEPTH},
+		{name: "one", depth: "1", want: 1},
+		{name: "max", depth: "20", want: MAX_THREAD_DEPTH},
+		{name: "zero", depth: "0", wantErr: true},
+		{name: "negative", depth: "-3", wantErr: true},
+		{name: "too deep", depth: "21", wantErr: true},
+		{name: "not a number", depth: "deep", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseThreadDepth(tt.depth)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseThreadDepth(%q) error = %v, wantErr %v", tt.depth, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseThreadDepth(%q) = %d, want %d", tt.depth, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildReplyTree(t *testing.T) {
+	ids := map[string]uuid.UUID{}
+	names := map[uuid.UUID]string{}
+	for _, name := range []string{"root", "a", "b", "c", "d", "gone"} {
+		id := uuid.New()
+		ids[name] = id
+		names[id] = name
+	}
+	// reply is a chirp named name replying to parent, or to nothing
+	reply := func(name, parent string) Chirp {
+		chirp := Chirp{ID: ids[name]}
+		if parent != "" {
+			inReplyTo := ids[parent]
+			chirp.InReplyTo = &inReplyTo
+		}
+		return chirp
+	}
+	// shape writes the tree as "a(b c(d))", children in order
+	var shape func(nodes []*ThreadNode) string
+	shape = func(nodes []*ThreadNode) string {
+		parts := []string{}
+		for _, node := range nodes {
+			part := names[node.ID]
+			if len(node.Replies) > 0 {
+				part += "(" + shape(node.Replies) + ")"
+			}
+			parts = append(parts, part)
+		}
+		return strings.Join(parts, " ")
+	}
+
+	tests := []struct {
+		name    string
+		replies []Chirp
+		want    string
+	}{
+		{name: "no replies", replies: nil, want: ""},
+		{
+			name:    "direct replies",
+			replies: []Chirp{reply("a", "root"), reply("b", "root")},
+			want:    "a b",
+		},
+		{
+			name:    "nested replies",
+			replies: []Chirp{reply("a", "root"), reply("b", "a"), reply("c", "b"), reply("d", "a")},
+			want:    "a(b(c) d)",
+		},
+		{
+			name:    "reply listed before its parent",
+			replies: []Chirp{reply("b", "a"), reply("a", "root")},
+			want:    "a(b)",
+		},
+		{
+			name:    "orphaned replies go to the top level",
+			replies: []Chirp{reply("a", "root"), reply("b", "gone"), reply("c", "b")},
+			want:    "a b(c)",
+		},
+		{
+			name:    "reply to nothing",
+			replies: []Chirp{reply("a", "")},
+			want:    "a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := buildReplyTree(tt.replies)
+			if tree == nil {
+				t.Fatal("buildReplyTree() = nil, want an empty list")
+			}
+			if got := shape(tree); got != tt.want {
+				t.Errorf("buildReplyTree() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
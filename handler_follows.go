@@ -0,0 +1,224 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+type followsPage struct {
+	Users      []Author `json:"users"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+// handlerFollowUser makes the caller follow a user. Following someone
+// again changes nothing.
+func (cfg *apiConfig) handlerFollowUser(w http.ResponseWriter, r *http.Request) {
+	followeeID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	followerID := principalFromRequest(r).UserID
+	if followeeID == followerID {
+		respondWithError(w, http.StatusBadRequest, "You can't follow yourself", nil)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting transaction", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	locked, err := qtx.LockFollowUsers(r.Context(), []uuid.UUID{followerID, followeeID})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if len(locked) < 2 {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", nil)
+		return
+	}
+
+	added, err := qtx.FollowUser(r.Context(), database.FollowUserParams{
+		FollowerID: followerID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't follow user", err)
+		return
+	}
+	if added > 0 {
+		err = qtx.AdjustFollowCounts(r.Context(), database.AdjustFollowCountsParams{
+			FolloweeID: followeeID,
+			Delta:      1,
+			FollowerID: followerID,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't follow user", err)
+			return
+		}
+		err = qtx.BackfillTimeline(r.Context(), database.BackfillTimelineParams{
+			UserID:     followerID,
+			AuthorID:   followeeID,
+			MaxEntries: TIMELINE_BACKFILL,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update timeline", err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't follow user", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerUnfollowUser stops the caller following a user, and takes the
+// user's chirps out of the caller's timeline.
+func (cfg *apiConfig) handlerUnfollowUser(w http.ResponseWriter, r *http.Request) {
+	followeeID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	followerID := principalFromRequest(r).UserID
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting transaction", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	_, err = qtx.LockFollowUsers(r.Context(), []uuid.UUID{followerID, followeeID})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	removed, err := qtx.UnfollowUser(r.Context(), database.UnfollowUserParams{
+		FollowerID: followerID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unfollow user", err)
+		return
+	}
+	if removed > 0 {
+		err = qtx.AdjustFollowCounts(r.Context(), database.AdjustFollowCountsParams{
+			FolloweeID: followeeID,
+			Delta:      -1,
+			FollowerID: followerID,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't unfollow user", err)
+			return
+		}
+		err = qtx.RemoveFromTimeline(r.Context(), database.RemoveFromTimelineParams{
+			UserID:   followerID,
+			AuthorID: followeeID,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update timeline", err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unfollow user", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerFollowers(w http.ResponseWriter, r *http.Request) {
+	cfg.respondWithFollows(w, r, true)
+}
+
+func (cfg *apiConfig) handlerFollowing(w http.ResponseWriter, r *http.Request) {
+	cfg.respondWithFollows(w, r, false)
+}
+
+// respondWithFollows lists who follows a user, or who the user follows,
+// most recent first.
+func (cfg *apiConfig) respondWithFollows(w http.ResponseWriter, r *http.Request, followers bool) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	query := r.URL.Query()
+	pageSize, err := parsePageSize(query.Get("limit"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	params := database.GetFollowersPageParams{
+		UserID: userID,
+		// fetch one extra row to find out whether there is a next page
+		PageSize: int32(pageSize + 1),
+	}
+	if cursorStr := query.Get("cursor"); cursorStr != "" {
+		cursor, err := decodeCursor(cursorStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+			return
+		}
+		params.CursorCreatedAt = sql.NullTime{Time: cursor.CreatedAt, Valid: true}
+		params.CursorID = uuid.NullUUID{UUID: cursor.ID, Valid: true}
+	}
+
+	_, err = cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "User not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	var users []database.GetFollowersPageRow
+	if followers {
+		users, err = cfg.dbQueries.GetFollowersPage(r.Context(), params)
+	} else {
+		var following []database.GetFollowingPageRow
+		following, err = cfg.dbQueries.GetFollowingPage(r.Context(), database.GetFollowingPageParams(params))
+		for _, user := range following {
+			users = append(users, database.GetFollowersPageRow(user))
+		}
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading follows", err)
+		return
+	}
+
+	page := followsPage{
+		Users: []Author{},
+	}
+	if len(users) > pageSize {
+		users = users[:pageSize]
+		// keyed on when the follow happened
+		last := users[len(users)-1]
+		page.NextCursor = encodeCursor(last.FollowedAt, last.ID)
+	}
+	for _, user := range users {
+		page.Users = append(page.Users, Author{
+			ID:          user.ID,
+			Handle:      user.Handle,
+			DisplayName: user.DisplayName,
+			AvatarURL:   user.AvatarUrl,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, page)
+}
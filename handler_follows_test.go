@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/circuit-shell/http-server-go/internal/auth"
+	"github.com/google/uuid"
+)
+
+func TestFollowUserRejectsBadTargets(t *testing.T) {
+	self := uuid.New()
+
+	tests := []struct {
+		name   string
+		userID string
+	}{
+		{name: "yourself", userID: self.String()},
+		{name: "not an ID", userID: "heisenberg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// no database: reaching it would panic instead of returning 400
+			cfg := &apiConfig{}
+
+			r := httptest.NewRequest(http.MethodPost, "/api/users/"+tt.userID+"/follow", nil)
+			r.SetPathValue("userID", tt.userID)
+			r = r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{UserID: self}))
+			w := httptest.NewRecorder()
+			cfg.handlerFollowUser(w, r)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d; body %s", w.Code, http.StatusBadRequest, w.Body)
+			}
+		})
+	}
+}
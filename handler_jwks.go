@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/circuit-shell/http-server-go/internal/auth"
+)
+
+// loadKeyring signs with the keys in keysDir when one is configured and
+// falls back to HS256 with the server secret otherwise. While asymmetric
+// keys are in use the server secret stays registered as a kid-less verify
+// key, so HS256 tokens issued before the switch remain valid until expiry.
+func loadKeyring(keysDir, activeKeyID, serverSecret string) (*auth.Keyring, error) {
+	if keysDir == "" {
+		return auth.NewKeyring(auth.NewHMACKey("", serverSecret))
+	}
+
+	keyring, err := auth.LoadKeyringDir(keysDir, activeKeyID)
+	if err != nil {
+		return nil, err
+	}
+	if serverSecret != "" {
+		err = keyring.Add(auth.NewHMACKey("", serverSecret))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keyring, nil
+}
+
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, cfg.keyring.JWKS())
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/circuit-shell/http-server-go/internal/storage"
+	"github.com/google/uuid"
+)
+
+const MAX_MEDIA_SIZE = 5 << 20
+
+// room for the multipart boundaries and headers around the file
+const MULTIPART_OVERHEAD = 64 << 10
+
+// MEDIA_TYPES maps the content types we accept, as sniffed from the
+// bytes, to the extension their blob keys get. Every upload is re-encoded,
+// so only formats the standard library can decode are allowed.
+var MEDIA_TYPES = map[string]string{
+	"image/gif":  ".gif",
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+// avatars are shown small, so they use the smallest rendition
+const AVATAR_RENDITION = "thumb"
+
+var errMediaTooLarge = errors.New("media is too large")
+var errMediaType = errors.New("unsupported media type")
+var errMediaMissing = errors.New("no file uploaded")
+var errBadUpload = errors.New("malformed upload")
+
+// loadBlobStore picks the storage backend from STORAGE_BACKEND: "s3" for
+// an S3-compatible bucket set up by the S3_* variables, or files below
+// MEDIA_DIR otherwise.
+func loadBlobStore(getenv func(string) string) (storage.BlobStore, error) {
+	switch getenv("STORAGE_BACKEND") {
+	case "s3":
+		config := storage.S3Config{
+			Endpoint:        getenv("S3_ENDPOINT"),
+			Region:          getenv("S3_REGION"),
+			Bucket:          getenv("S3_BUCKET"),
+			AccessKeyID:     getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: getenv("S3_SECRET_ACCESS_KEY"),
+		}
+		if config.Endpoint == "" || config.Bucket == "" || config.AccessKeyID == "" || config.SecretAccessKey == "" {
+			return nil, errors.New("S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required")
+		}
+		if config.Region == "" {
+			config.Region = "us-east-1"
+		}
+		return storage.NewS3Store(config, nil), nil
+	case "", "local":
+		dir := getenv("MEDIA_DIR")
+		if dir == "" {
+			dir = "media"
+		}
+		return storage.NewLocalStore(dir)
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q", getenv("STORAGE_BACKEND"))
+	}
+}
+
+// Media is an upload; its renditions are listed once Status is "ready".
+// ContentType and Size are those of the original.
+type Media struct {
+	ID          uuid.UUID            `json:"id"`
+	Status      string               `json:"status"`
+	Error       string               `json:"error,omitempty"`
+	ContentType string               `json:"content_type"`
+	Size        int64                `json:"size"`
+	Width       int32                `json:"width,omitempty"`
+	Height      int32                `json:"height,omitempty"`
+	Renditions  map[string]Rendition `json:"renditions"`
+	CreatedAt   time.Time            `json:"created_at"`
+}
+
+type Rendition struct {
+	URL    string `json:"url"`
+	Width  int32  `json:"width"`
+	Height int32  `json:"height"`
+}
+
+func (cfg *apiConfig) mediaFromDatabase(media database.Medium, renditions []database.MediaRendition) Media {
+	result := Media{
+		ID:          media.ID,
+		Status:      media.Status,
+		Error:       media.Error,
+		ContentType: media.ContentType,
+		Size:        media.SizeBytes,
+		Width:       media.Width,
+		Height:      media.Height,
+		Renditions:  map[string]Rendition{},
+		CreatedAt:   media.CreatedAt,
+	}
+	for _, rendition := range renditions {
+		result.Renditions[rendition.Name] = Rendition{
+			URL:    cfg.mediaURL(rendition.BlobKey),
+			Width:  rendition.Width,
+			Height: rendition.Height,
+		}
+	}
+	return result
+}
+
+// mediaURL is where handlerServeMedia serves a rendition; their keys all
+// start with "media/".
+func (cfg *apiConfig) mediaURL(key string) string {
+	return cfg.appBaseURL + "/" + key
+}
+
+// readUpload returns the uploaded file, which is either the "file" field
+// of a multipart form or the whole request body.
+func readUpload(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, MAX_MEDIA_SIZE+MULTIPART_OVERHEAD)
+
+	var file io.Reader = r.Body
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			return nil, uploadError(err)
+		}
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return nil, errMediaMissing
+			}
+			if err != nil {
+				return nil, uploadError(err)
+			}
+			if part.FormName() == "file" {
+				file = part
+				break
+			}
+		}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, MAX_MEDIA_SIZE+1))
+	if err != nil {
+		return nil, uploadError(err)
+	}
+	if len(data) > MAX_MEDIA_SIZE {
+		return nil, errMediaTooLarge
+	}
+	if len(data) == 0 {
+		return nil, errMediaMissing
+	}
+	return data, nil
+}
+
+func uploadError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return errMediaTooLarge
+	}
+	return fmt.Errorf("%w: %w", errBadUpload, err)
+}
+
+// storeMedia saves an upload for processing. The original is kept below
+// "uploads/", which is never served, until its renditions exist. The
+// content type comes from sniffing the bytes; whatever the client claims
+// is ignored. Media that is claimed is left to the caller to process.
+func (cfg *apiConfig) storeMedia(ctx context.Context, userID uuid.UUID, data []byte, claimed bool) (database.Medium, error) {
+	contentType := http.DetectContentType(data)
+	ext, ok := MEDIA_TYPES[contentType]
+	if !ok {
+		return database.Medium{}, errMediaType
+	}
+
+	key := "uploads/" + uuid.NewString() + ext
+	err := cfg.blobs.Put(ctx, key, data, contentType)
+	if err != nil {
+		return database.Medium{}, err
+	}
+	return cfg.dbQueries.CreateMedia(ctx, database.CreateMediaParams{
+		UserID:      userID,
+		BlobKey:     key,
+		ContentType: contentType,
+		SizeBytes:   int64(len(data)),
+		ClaimedAt:   sql.NullTime{Time: time.Now(), Valid: claimed},
+	})
+}
+
+func respondWithUploadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errMediaTooLarge):
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Media must be at most 5 MiB", err)
+	case errors.Is(err, errMediaType):
+		respondWithError(w, http.StatusUnsupportedMediaType, "Media must be a JPEG, PNG or GIF image", err)
+	case errors.Is(err, errMediaMissing):
+		respondWithError(w, http.StatusBadRequest, "No file uploaded", err)
+	case errors.Is(err, errBadUpload):
+		respondWithError(w, http.StatusBadRequest, "Couldn't read upload", err)
+	default:
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store media", err)
+	}
+}
+
+func (cfg *apiConfig) handlerUploadMedia(w http.ResponseWriter, r *http.Request) {
+	userID := principalFromRequest(r).UserID
+
+	data, err := readUpload(w, r)
+	if err != nil {
+		respondWithUploadError(w, err)
+		return
+	}
+	media, err := cfg.storeMedia(r.Context(), userID, data, false)
+	if err != nil {
+		respondWithUploadError(w, err)
+		return
+	}
+	cfg.notifyMediaQueued()
+
+	// GET /api/media/{mediaID} tells when it is ready
+	respondWithJSON(w, http.StatusAccepted, cfg.mediaFromDatabase(media, nil))
+}
+
+func (cfg *apiConfig) handlerGetMedia(w http.ResponseWriter, r *http.Request) {
+	mediaID, err := uuid.Parse(r.PathValue("mediaID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid media ID", err)
+		return
+	}
+
+	media, err := cfg.dbQueries.GetMedia(r.Context(), mediaID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && media.UserID != principalFromRequest(r).UserID) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find media", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get media", err)
+		return
+	}
+	renditions, err := cfg.dbQueries.GetMediaRenditions(r.Context(), mediaID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get media", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, cfg.mediaFromDatabase(media, renditions))
+}
+
+// handlerUploadAvatar makes an image the user's avatar. Unlike other
+// uploads it is processed right away, so the response has the final URL.
+func (cfg *apiConfig) handlerUploadAvatar(w http.ResponseWriter, r *http.Request) {
+	userID := principalFromRequest(r).UserID
+
+	data, err := readUpload(w, r)
+	if err != nil {
+		respondWithUploadError(w, err)
+		return
+	}
+	media, err := cfg.storeMedia(r.Context(), userID, data, true)
+	if err != nil {
+		respondWithUploadError(w, err)
+		return
+	}
+	err = cfg.processMedia(r.Context(), media)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't process avatar", err)
+		return
+	}
+
+	media, err = cfg.dbQueries.GetMedia(r.Context(), media.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get avatar", err)
+		return
+	}
+	if media.Status == "failed" {
+		respondWithError(w, http.StatusUnprocessableEntity, media.Error, nil)
+		return
+	}
+	renditions, err := cfg.dbQueries.GetMediaRenditions(r.Context(), media.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get avatar", err)
+		return
+	}
+	avatar, ok := cfg.mediaFromDatabase(media, renditions).Renditions[AVATAR_RENDITION]
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get avatar", nil)
+		return
+	}
+
+	user, err := cfg.dbQueries.PatchUser(r.Context(), database.PatchUserParams{
+		ID:        userID,
+		AvatarUrl: sql.NullString{String: avatar.URL, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error updating user", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, userFromDatabase(user))
+}
+
+// handlerServeMedia serves uploaded files. Their keys are content hashes,
+// so a URL always means the same bytes and may be cached forever.
+func (cfg *apiConfig) handlerServeMedia(w http.ResponseWriter, r *http.Request) {
+	key := "media/" + r.PathValue("path")
+	if storage.ValidateKey(key) != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// the hash in the key is all a cache needs to revalidate
+	etag := `"` + strings.TrimSuffix(path.Base(key), path.Ext(key)) + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		setMediaCacheHeaders(w, etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	blob, err := cfg.blobs.Get(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read media", err)
+		return
+	}
+	defer blob.Body.Close()
+
+	setMediaCacheHeaders(w, etag)
+	// never let a browser treat an upload as anything but an image
+	w.Header().Set("Content-Type", blob.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	if blob.Size > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(blob.Size, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		io.Copy(w, blob.Body)
+	}
+}
+
+func setMediaCacheHeaders(w http.ResponseWriter, etag string) {
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", etag)
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/circuit-shell/http-server-go/internal/auth"
+	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/circuit-shell/http-server-go/internal/qrcode"
+	"github.com/google/uuid"
+)
+
+const TOTP_ISSUER = "Chirpy"
+const MFA_TOKEN_EXPIRES_IN = 5 * time.Minute
+const RECOVERY_CODE_COUNT = 10
+
+type mfaChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+// respondWithMFAChallenge answers a login whose first factor succeeded
+// with a short-lived challenge token for POST /api/login/mfa.
+func (cfg *apiConfig) respondWithMFAChallenge(w http.ResponseWriter, userID uuid.UUID) {
+	mfaToken, err := cfg.keyring.MakeMFAToken(userID, MFA_TOKEN_EXPIRES_IN)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error generating token", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, mfaChallenge{
+		MFARequired: true,
+		MFAToken:    mfaToken,
+	})
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused
+// recovery code, and burns whichever was used so it can't be replayed.
+func checkSecondFactor(ctx context.Context, q *database.Queries, totp database.UserTotp, code string) (bool, error) {
+	step, ok := auth.ValidateTOTP(totp.Secret, code, time.Now().UTC())
+	if ok {
+		used, err := q.UseTOTPStep(ctx, database.UseTOTPStepParams{
+			UserID:       totp.UserID,
+			LastUsedStep: step,
+		})
+		if err != nil {
+			return false, err
+		}
+		return used == 1, nil
+	}
+
+	used, err := q.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{
+		UserID:   totp.UserID,
+		CodeHash: auth.HashRecoveryCode(code),
+	})
+	if err != nil {
+		return false, err
+	}
+	return used == 1, nil
+}
+
+// mfaEnabled reports whether userID has a confirmed TOTP secret.
+func mfaEnabled(ctx context.Context, q *database.Queries, userID uuid.UUID) (bool, error) {
+	totp, err := q.GetUserTOTP(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return totp.ConfirmedAt.Valid, nil
+}
+
+func (cfg *apiConfig) handlerEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+		QRCodeURL  string `json:"qr_code_url"`
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), principalFromRequest(r).UserID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user", err)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error generating TOTP secret", err)
+		return
+	}
+
+	// a pending enrollment is replaced, a confirmed one is left alone
+	totp, err := cfg.dbQueries.UpsertUserTOTP(r.Context(), database.UpsertUserTOTPParams{
+		UserID: user.ID,
+		Secret: secret,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error saving TOTP secret", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		Secret:     totp.Secret,
+		OTPAuthURI: auth.TOTPURI(TOTP_ISSUER, user.Email, totp.Secret),
+		QRCodeURL:  "/api/mfa/totp/qr.png",
+	})
+}
+
+func (cfg *apiConfig) handlerTOTPQRCode(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), principalFromRequest(r).UserID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user", err)
+		return
+	}
+
+	// the secret is only shown while the enrollment is pending
+	totp, err := cfg.dbQueries.GetUserTOTP(r.Context(), user.ID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && totp.ConfirmedAt.Valid) {
+		respondWithError(w, http.StatusNotFound, "No pending TOTP enrollment", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get TOTP enrollment", err)
+		return
+	}
+
+	code, err := qrcode.Encode(auth.TOTPURI(TOTP_ISSUER, user.Email, totp.Secret))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error encoding QR code", err)
+		return
+	}
+	data, err := code.PNG(8)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error encoding QR code", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+func (cfg *apiConfig) handlerConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	userID := principalFromRequest(r).UserID
+	totp, err := cfg.dbQueries.GetUserTOTP(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && totp.ConfirmedAt.Valid) {
+		respondWithError(w, http.StatusNotFound, "No pending TOTP enrollment", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get TOTP enrollment", err)
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(totp.Secret, params.Code, time.Now().UTC())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Invalid code", nil)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting transaction", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	// the confirming code counts as used
+	confirmed, err := qtx.ConfirmUserTOTP(r.Context(), database.ConfirmUserTOTPParams{
+		UserID:       userID,
+		LastUsedStep: step,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error confirming TOTP", err)
+		return
+	}
+	if confirmed == 0 {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+
+	err = qtx.DeleteRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error saving recovery codes", err)
+		return
+	}
+	codes := make([]string, 0, RECOVERY_CODE_COUNT)
+	for i := 0; i < RECOVERY_CODE_COUNT; i++ {
+		code, err := auth.MakeRecoveryCode()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error generating recovery codes", err)
+			return
+		}
+		err = qtx.CreateRecoveryCode(r.Context(), database.CreateRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: auth.HashRecoveryCode(code),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error saving recovery codes", err)
+			return
+		}
+		codes = append(codes, code)
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error confirming TOTP", err)
+		return
+	}
+
+	// recovery codes are only ever shown here
+	respondWithJSON(w, http.StatusOK, response{RecoveryCodes: codes})
+}
+
+func (cfg *apiConfig) handlerDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	userID := principalFromRequest(r).UserID
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting transaction", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	totp, err := qtx.GetUserTOTP(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Two-factor authentication is not enabled", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get TOTP enrollment", err)
+		return
+	}
+
+	// a stolen access token alone must not be enough to turn MFA off
+	if totp.ConfirmedAt.Valid {
+		ok, err := checkSecondFactor(r.Context(), qtx, totp, params.Code)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+			return
+		}
+		if !ok {
+			respondWithError(w, http.StatusUnauthorized, "Invalid code", nil)
+			return
+		}
+	}
+
+	_, err = qtx.DeleteUserTOTP(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error disabling TOTP", err)
+		return
+	}
+	err = qtx.DeleteRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error disabling TOTP", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error disabling TOTP", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleLoginMFA is the second login step: it exchanges the challenge
+// token from handleLogin plus a TOTP or recovery code for real tokens.
+func (cfg *apiConfig) handleLoginMFA(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MFAToken   string `json:"mfa_token"`
+		Code       string `json:"code"`
+		DeviceName string `json:"device_name"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	userID, err := cfg.keyring.ValidateMFAToken(params.MFAToken)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid MFA token", err)
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid user", err)
+		return
+	}
+
+	// codes are guessable, so they share the password's backoff
+	accountKey, ipKey := accountLoginKey(user.Email), ipLoginKey(r)
+	wait, err := cfg.loginRetryAfter(r.Context(), accountKey, ipKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check login attempts", err)
+		return
+	}
+	if wait > 0 {
+		respondTooManyAttempts(w, wait)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting transaction", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	totp, err := qtx.GetUserTOTP(r.Context(), userID)
+	if err != nil || !totp.ConfirmedAt.Valid {
+		respondWithError(w, http.StatusUnauthorized, "Invalid MFA token", err)
+		return
+	}
+
+	ok, err := checkSecondFactor(r.Context(), qtx, totp, params.Code)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+		return
+	}
+	if !ok {
+		err = cfg.recordFailedLogin(r.Context(), accountKey, ipKey)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record login attempt", err)
+			return
+		}
+		respondWithError(w, http.StatusUnauthorized, "Invalid code", nil)
+		return
+	}
+	err = qtx.ClearLoginAttempts(r.Context(), accountKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record login attempt", err)
+		return
+	}
+
+	authenticated, err := cfg.issueTokens(r.Context(), qtx, user, newSessionInfo(r, params.DeviceName))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error generating tokens", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error saving refresh token", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, authenticated)
+}
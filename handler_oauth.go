@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/circuit-shell/http-server-go/internal/auth"
+	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+// oauthError is the error body of the token, revocation and introspection
+// endpoints, as in RFC 6749 section 5.2.
+type oauthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+func respondWithOAuthError(w http.ResponseWriter, code int, oauthCode, description string, err error) {
+	if err != nil {
+		log.Println(err)
+	}
+	if code > 499 {
+		log.Printf("Responding with 5XX error: %s", description)
+	}
+	if code == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, code, oauthError{
+		Error:            oauthCode,
+		ErrorDescription: description,
+	})
+}
+
+var errInvalidClient = errors.New("invalid client credentials")
+
+// authenticateClient identifies the client calling a back channel
+// endpoint. Confidential clients authenticate with HTTP Basic or with
+// client_id and client_secret in the form; public clients send only
+// their client_id.
+func (cfg *apiConfig) authenticateClient(r *http.Request) (database.OauthClient, error) {
+	clientID, secret, hasBasic := r.BasicAuth()
+	if hasBasic {
+		// RFC 6749 section 2.3.1 form-encodes both before Basic encoding
+		var err error
+		clientID, err = url.QueryUnescape(clientID)
+		if err != nil {
+			return database.OauthClient{}, errInvalidClient
+		}
+		secret, err = url.QueryUnescape(secret)
+		if err != nil {
+			return database.OauthClient{}, errInvalidClient
+		}
+		if formID := r.PostFormValue("client_id"); formID != "" && formID != clientID {
+			return database.OauthClient{}, errInvalidClient
+		}
+	} else {
+		clientID = r.PostFormValue("client_id")
+		secret = r.PostFormValue("client_secret")
+	}
+	if clientID == "" {
+		return database.OauthClient{}, errInvalidClient
+	}
+
+	client, err := cfg.dbQueries.GetOAuthClient(r.Context(), clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.OauthClient{}, errInvalidClient
+	}
+	if err != nil {
+		return database.OauthClient{}, err
+	}
+
+	if !client.SecretHash.Valid {
+		if secret != "" {
+			return database.OauthClient{}, errInvalidClient
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(client.SecretHash.String)) != 1 {
+		return database.OauthClient{}, errInvalidClient
+	}
+	return client, nil
+}
+
+// respondClientError answers a failed authenticateClient.
+func respondClientError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errInvalidClient) {
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed", err)
+		return
+	}
+	respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "couldn't authenticate client", err)
+}
+
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+func respondWithTokens(w http.ResponseWriter, token, refreshToken string, principal auth.Principal) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	respondWithJSON(w, http.StatusOK, oauthTokenResponse{
+		AccessToken:  token,
+		TokenType:    "Bearer",
+		ExpiresIn:    EXPIRES_IN_SECONDS,
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(principal.Scopes, " "),
+	})
+}
+
+// handlerOAuthToken is the token endpoint. It exchanges authorization
+// codes and rotates refresh tokens issued to the calling client.
+func (cfg *apiConfig) handlerOAuthToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "couldn't parse form", err)
+		return
+	}
+
+	client, err := cfg.authenticateClient(r)
+	if err != nil {
+		respondClientError(w, err)
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		cfg.exchangeAuthorizationCode(w, r, client)
+	case "refresh_token":
+		refreshToken := r.PostForm.Get("refresh_token")
+		if refreshToken == "" {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "refresh_token is required", nil)
+			return
+		}
+		refreshed, err := cfg.rotateRefreshToken(r, refreshToken, client.ID)
+		if errors.Is(err, errInvalidRefreshToken) {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "invalid refresh token", err)
+			return
+		}
+		if err != nil {
+			respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "couldn't rotate refresh token", err)
+			return
+		}
+		respondWithTokens(w, refreshed.Token, refreshed.RefreshToken, refreshed.Principal)
+	case "":
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "grant_type is required", nil)
+	default:
+		respondWithOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code and refresh_token are supported", nil)
+	}
+}
+
+func (cfg *apiConfig) exchangeAuthorizationCode(w http.ResponseWriter, r *http.Request, client database.OauthClient) {
+	code := r.PostForm.Get("code")
+	redirectURI := r.PostForm.Get("redirect_uri")
+	verifier := r.PostForm.Get("code_verifier")
+	if code == "" || redirectURI == "" || verifier == "" {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "code, redirect_uri and code_verifier are required", nil)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "couldn't start transaction", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	codeHash := auth.HashToken(code)
+	authCode, err := qtx.ConsumeAuthorizationCode(r.Context(), codeHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = cfg.revokeReplayedCode(r.Context(), codeHash, client.ID)
+		if err != nil {
+			respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "couldn't revoke tokens", err)
+			return
+		}
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "invalid, expired or used authorization code", nil)
+		return
+	}
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "couldn't get authorization code", err)
+		return
+	}
+
+	// a code that fails any check stays used up
+	if authCode.ClientID != client.ID || authCode.RedirectUri != redirectURI || !auth.VerifyPKCE(verifier, authCode.CodeChallenge) {
+		if err := tx.Commit(); err != nil {
+			respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "couldn't use authorization code", err)
+			return
+		}
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "authorization code doesn't match the request", nil)
+		return
+	}
+
+	user, err := qtx.GetUserByID(r.Context(), authCode.UserID)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "invalid user", err)
+		return
+	}
+
+	// the role may have changed since consent
+	granted := auth.ParseScope(authCode.Scope)
+	scopes, _ := auth.GrantScopes(granted, granted, auth.Role(user.Role))
+	principal := auth.NewOAuthPrincipal(user.ID, auth.Role(user.Role), scopes, client.ID).WithSession(authCode.FamilyID)
+
+	// the session list shows the client's name rather than its server's
+	// user agent
+	token, refreshToken, err := cfg.startSession(r.Context(), qtx, principal, newSessionInfo(r, client.Name))
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "couldn't issue tokens", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "couldn't save refresh token", err)
+		return
+	}
+
+	respondWithTokens(w, token, refreshToken, principal)
+}
+
+// revokeReplayedCode revokes the tokens issued from an authorization code
+// that is presented a second time, since either the first or the second
+// exchange was made by an attacker (RFC 6749 section 4.1.2).
+func (cfg *apiConfig) revokeReplayedCode(ctx context.Context, codeHash, clientID string) error {
+	authCode, err := cfg.dbQueries.GetAuthorizationCode(ctx, codeHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !authCode.UsedAt.Valid || authCode.ClientID != clientID {
+		return nil
+	}
+	return cfg.dbQueries.RevokeRefreshTokenFamily(ctx, authCode.FamilyID)
+}
+
+// handlerOAuthRevoke implements RFC 7009. Revoking either token of a
+// session ends the session; access tokens already issued stay valid
+// until they expire but introspect as inactive. Unknown tokens and
+// tokens of other clients are ignored, as the RFC requires.
+func (cfg *apiConfig) handlerOAuthRevoke(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "couldn't parse form", err)
+		return
+	}
+
+	client, err := cfg.authenticateClient(r)
+	if err != nil {
+		respondClientError(w, err)
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "token is required", nil)
+		return
+	}
+
+	familyID := uuid.Nil
+	refreshToken, err := cfg.dbQueries.GetRefreshToken(r.Context(), auth.HashToken(token))
+	if err == nil && refreshToken.ClientID.String == client.ID {
+		familyID = refreshToken.FamilyID
+	} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "couldn't get token", err)
+		return
+	} else if principal, err := cfg.keyring.ValidateJWT(token); err == nil && principal.ClientID == client.ID {
+		familyID = principal.SessionID
+	}
+
+	if familyID != uuid.Nil {
+		err = cfg.dbQueries.RevokeRefreshTokenFamily(r.Context(), familyID)
+		if err != nil {
+			respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "couldn't revoke token", err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+type introspectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+}
+
+// handlerOAuthIntrospect implements RFC 7662 for the calling client's own
+// tokens; every other token is reported inactive.
+func (cfg *apiConfig) handlerOAuthIntrospect(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "couldn't parse form", err)
+		return
+	}
+
+	client, err := cfg.authenticateClient(r)
+	if err != nil {
+		respondClientError(w, err)
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "token is required", nil)
+		return
+	}
+
+	response, err := cfg.introspect(r.Context(), token, client.ID)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "couldn't introspect token", err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) introspect(ctx context.Context, token, clientID string) (introspectionResponse, error) {
+	refreshToken, err := cfg.dbQueries.GetUserFromRefreshToken(ctx, auth.HashToken(token))
+	if err == nil {
+		if refreshToken.ClientID.String != clientID || !refreshToken.ExpiresAt.After(time.Now().UTC()) {
+			return introspectionResponse{}, nil
+		}
+		return introspectionResponse{
+			Active:    true,
+			Scope:     refreshToken.Scope.String,
+			ClientID:  clientID,
+			Subject:   refreshToken.UserID.String(),
+			ExpiresAt: refreshToken.ExpiresAt.Unix(),
+			IssuedAt:  refreshToken.CreatedAt.Unix(),
+		}, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return introspectionResponse{}, err
+	}
+
+	principal, claims, err := cfg.keyring.IntrospectJWT(token)
+	if err != nil || principal.ClientID != clientID || principal.SessionID == uuid.Nil {
+		return introspectionResponse{}, nil
+	}
+	// a revoked session takes its access tokens with it
+	active, err := cfg.dbQueries.IsSessionActive(ctx, principal.SessionID)
+	if err != nil || !active {
+		return introspectionResponse{}, err
+	}
+	return introspectionResponse{
+		Active:    true,
+		Scope:     strings.Join(principal.Scopes, " "),
+		ClientID:  clientID,
+		Subject:   principal.UserID.String(),
+		ExpiresAt: claims.ExpiresAt.Unix(),
+		IssuedAt:  claims.IssuedAt.Unix(),
+		TokenType: "Bearer",
+	}, nil
+}
+
+// handlerOAuthMetadata publishes the authorization server metadata of
+// RFC 8414 so clients can discover the endpoints.
+func (cfg *apiConfig) handlerOAuthMetadata(w http.ResponseWriter, r *http.Request) {
+	type metadata struct {
+		Issuer                            string   `json:"issuer"`
+		AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+		TokenEndpoint                     string   `json:"token_endpoint"`
+		RevocationEndpoint                string   `json:"revocation_endpoint"`
+		IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+		JWKSURI                           string   `json:"jwks_uri"`
+		ScopesSupported                   []string `json:"scopes_supported"`
+		ResponseTypesSupported            []string `json:"response_types_supported"`
+		GrantTypesSupported               []string `json:"grant_types_supported"`
+		CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+		TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, metadata{
+		Issuer:                            cfg.appBaseURL,
+		AuthorizationEndpoint:             cfg.appBaseURL + "/oauth/authorize",
+		TokenEndpoint:                     cfg.appBaseURL + "/oauth/token",
+		RevocationEndpoint:                cfg.appBaseURL + "/oauth/revoke",
+		IntrospectionEndpoint:             cfg.appBaseURL + "/oauth/introspect",
+		JWKSURI:                           cfg.appBaseURL + "/.well-known/jwks.json",
+		ScopesSupported:                   auth.OAuthScopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token"},
+		CodeChallengeMethodsSupported:     []string{auth.PKCEMethodS256},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+	})
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/circuit-shell/http-server-go/internal/auth"
+	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+// OAUTH_SCOPE_DESCRIPTIONS is what the consent page tells the user each
+// scope allows.
+var OAUTH_SCOPE_DESCRIPTIONS = map[string]string{
+	auth.ScopeChirpsWrite:    "Post and delete chirps as you",
+	auth.ScopeChirpsModerate: "Moderate other people's chirps",
+}
+
+// authorizeRequest holds the parameters of an authorization request. The
+// consent form posts them back unchanged.
+type authorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+func parseAuthorizeRequest(values url.Values) authorizeRequest {
+	return authorizeRequest{
+		ResponseType:        values.Get("response_type"),
+		ClientID:            values.Get("client_id"),
+		RedirectURI:         values.Get("redirect_uri"),
+		Scope:               values.Get("scope"),
+		State:               values.Get("state"),
+		CodeChallenge:       values.Get("code_challenge"),
+		CodeChallengeMethod: values.Get("code_challenge_method"),
+	}
+}
+
+// authorizeClient looks up the client and checks the redirect URI. Until
+// both are known to be good, errors can't be sent back to the client and
+// are shown to the user instead (RFC 6749 section 4.1.2.1).
+func (cfg *apiConfig) authorizeClient(ctx context.Context, req authorizeRequest) (database.OauthClient, error) {
+	client, err := cfg.dbQueries.GetOAuthClient(ctx, req.ClientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.OauthClient{}, errors.New("unknown client")
+	}
+	if err != nil {
+		return database.OauthClient{}, err
+	}
+	// redirect URIs are compared exactly, never by prefix
+	if !slices.Contains(client.RedirectUris, req.RedirectURI) {
+		return database.OauthClient{}, errors.New("redirect_uri is not registered for this client")
+	}
+	return client, nil
+}
+
+// checkAuthorizeRequest returns the OAuth error code and description for
+// an invalid request, or an empty code.
+func checkAuthorizeRequest(req authorizeRequest, client database.OauthClient) (string, string) {
+	if req.ResponseType != "code" {
+		return "unsupported_response_type", "only the authorization code flow is supported"
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != auth.PKCEMethodS256 {
+		return "invalid_request", "PKCE with code_challenge_method S256 is required"
+	}
+	_, err := auth.GrantScopes(auth.ParseScope(req.Scope), client.Scopes, auth.RoleAdmin)
+	if err != nil {
+		return "invalid_scope", err.Error()
+	}
+	return "", ""
+}
+
+// redirectToClient sends the user agent back to the client with params
+// and the request's state added to the redirect URI's query.
+func redirectToClient(w http.ResponseWriter, r *http.Request, req authorizeRequest, params url.Values) {
+	// checked against the registered URIs, which were validated on creation
+	u, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Invalid redirect URI", err)
+		return
+	}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	u.RawQuery = query.Encode()
+	http.Redirect(w, r, u.String(), http.StatusSeeOther)
+}
+
+func redirectWithOAuthError(w http.ResponseWriter, r *http.Request, req authorizeRequest, code, description string) {
+	redirectToClient(w, r, req, url.Values{
+		"error":             {code},
+		"error_description": {description},
+	})
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{if .ClientName}}Authorize {{.ClientName}}{{else}}Authorization error{{end}} - Chirpy</title>
+<style>
+body { font-family: sans-serif; max-width: 28rem; margin: 3rem auto; padding: 0 1rem; }
+label { display: block; margin-top: 1rem; }
+input[type=email], input[type=password], input[type=text] { width: 100%; padding: 0.4rem; box-sizing: border-box; }
+.error { color: #b00020; }
+.actions { margin-top: 1.5rem; display: flex; gap: 1rem; }
+</style>
+</head>
+<body>
+{{if .ClientName}}
+<h1>Authorize {{.ClientName}}</h1>
+<p><strong>{{.ClientName}}</strong> wants to access your Chirpy account. It will be able to:</p>
+<ul>
+{{range .Scopes}}<li>{{.}}</li>
+{{end}}</ul>
+<p>It will never see your password.</p>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="post" action="/oauth/authorize">
+<input type="hidden" name="response_type" value="{{.Request.ResponseType}}">
+<input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+<input type="hidden" name="scope" value="{{.Request.Scope}}">
+<input type="hidden" name="state" value="{{.Request.State}}">
+<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+<label>Email <input type="email" name="email" value="{{.Email}}" autocomplete="username" required></label>
+<label>Password <input type="password" name="password" autocomplete="current-password" required></label>
+<label>Two-factor code, if enabled <input type="text" name="totp_code" autocomplete="one-time-code"></label>
+<div class="actions">
+<button type="submit" name="action" value="allow">Allow</button>
+<button type="submit" name="action" value="deny" formnovalidate>Deny</button>
+</div>
+</form>
+{{else}}
+<h1>Authorization error</h1>
+<p class="error">{{.Error}}</p>
+<p>The application that sent you here is misconfigured. You can close this page.</p>
+{{end}}
+</body>
+</html>
+`))
+
+type consentPage struct {
+	Request    authorizeRequest
+	ClientName string
+	Scopes     []string
+	Email      string
+	Error      string
+}
+
+// renderConsent writes the consent page, or an error page when
+// ClientName is empty. The page takes a password, so it must not be
+// framed by another site.
+func renderConsent(w http.ResponseWriter, code int, page consentPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	w.WriteHeader(code)
+	err := consentTemplate.Execute(w, page)
+	if err != nil {
+		log.Printf("Error rendering consent page: %s", err)
+	}
+}
+
+func newConsentPage(req authorizeRequest, client database.OauthClient) consentPage {
+	requested := auth.ParseScope(req.Scope)
+	if len(requested) == 0 {
+		requested = client.Scopes
+	}
+	scopes := []string{}
+	for _, scope := range requested {
+		scopes = append(scopes, OAUTH_SCOPE_DESCRIPTIONS[scope])
+	}
+	return consentPage{
+		Request:    req,
+		ClientName: client.Name,
+		Scopes:     scopes,
+	}
+}
+
+// handlerOAuthAuthorize shows the consent page of an authorization
+// request.
+func (cfg *apiConfig) handlerOAuthAuthorize(w http.ResponseWriter, r *http.Request) {
+	req := parseAuthorizeRequest(r.URL.Query())
+	client, err := cfg.authorizeClient(r.Context(), req)
+	if err != nil {
+		log.Println(err)
+		renderConsent(w, http.StatusBadRequest, consentPage{Error: "Invalid authorization request: " + err.Error()})
+		return
+	}
+	if code, description := checkAuthorizeRequest(req, client); code != "" {
+		redirectWithOAuthError(w, r, req, code, description)
+		return
+	}
+
+	renderConsent(w, http.StatusOK, newConsentPage(req, client))
+}
+
+// handlerOAuthConsent handles the consent form: it logs the user in the
+// same way POST /api/login does and sends an authorization code back to
+// the client.
+func (cfg *apiConfig) handlerOAuthConsent(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		renderConsent(w, http.StatusBadRequest, consentPage{Error: "Invalid form"})
+		return
+	}
+	req := parseAuthorizeRequest(r.PostForm)
+	client, err := cfg.authorizeClient(r.Context(), req)
+	if err != nil {
+		log.Println(err)
+		renderConsent(w, http.StatusBadRequest, consentPage{Error: "Invalid authorization request: " + err.Error()})
+		return
+	}
+	if code, description := checkAuthorizeRequest(req, client); code != "" {
+		redirectWithOAuthError(w, r, req, code, description)
+		return
+	}
+
+	if r.PostForm.Get("action") != "allow" {
+		redirectWithOAuthError(w, r, req, "access_denied", "the user denied the request")
+		return
+	}
+
+	email := r.PostForm.Get("email")
+	password := r.PostForm.Get("password")
+	page := newConsentPage(req, client)
+	page.Email = email
+
+	// the consent form is a login form, so it gets the same backoff
+	accountKey, ipKey := accountLoginKey(email), ipLoginKey(r)
+	wait, err := cfg.loginRetryAfter(r.Context(), accountKey, ipKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check login attempts", err)
+		return
+	}
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		page.Error = "Too many failed login attempts, try again later"
+		renderConsent(w, http.StatusTooManyRequests, page)
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByEmail(r.Context(), email)
+	if errors.Is(err, sql.ErrNoRows) {
+		cfg.passwords.CheckDummy(password)
+		cfg.rejectConsent(w, r, page, accountKey, ipKey, LOGIN_FAILED_MESSAGE)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	ok, needsRehash := cfg.passwords.Check(password, user.HashedPassword)
+	if !ok {
+		cfg.rejectConsent(w, r, page, accountKey, ipKey, LOGIN_FAILED_MESSAGE)
+		return
+	}
+	if needsRehash {
+		cfg.rehashPassword(r.Context(), user, password)
+	}
+
+	totp, err := cfg.dbQueries.GetUserTOTP(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor authentication", err)
+		return
+	}
+	if err == nil && totp.ConfirmedAt.Valid {
+		totpCode := strings.TrimSpace(r.PostForm.Get("totp_code"))
+		if totpCode == "" {
+			page.Error = "Enter the code from your authenticator app or a recovery code"
+			renderConsent(w, http.StatusUnauthorized, page)
+			return
+		}
+		ok, err := checkSecondFactor(r.Context(), cfg.dbQueries, totp, totpCode)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+			return
+		}
+		if !ok {
+			cfg.rejectConsent(w, r, page, accountKey, ipKey, "Invalid code")
+			return
+		}
+	}
+
+	err = cfg.dbQueries.ClearLoginAttempts(r.Context(), accountKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record login attempt", err)
+		return
+	}
+
+	// the client never gets more than the user's role allows
+	scopes, _ := auth.GrantScopes(auth.ParseScope(req.Scope), client.Scopes, auth.Role(user.Role))
+	if len(scopes) == 0 {
+		redirectWithOAuthError(w, r, req, "invalid_scope", "the user may not grant any of the requested scopes")
+		return
+	}
+
+	code, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error generating authorization code", err)
+		return
+	}
+	err = cfg.dbQueries.CreateAuthorizationCode(r.Context(), database.CreateAuthorizationCodeParams{
+		CodeHash:      auth.HashToken(code),
+		ClientID:      client.ID,
+		UserID:        user.ID,
+		RedirectUri:   req.RedirectURI,
+		Scope:         strings.Join(scopes, " "),
+		CodeChallenge: req.CodeChallenge,
+		FamilyID:      uuid.New(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error saving authorization code", err)
+		return
+	}
+
+	redirectToClient(w, r, req, url.Values{"code": {code}})
+}
+
+// rejectConsent records a failed login and shows the consent page again
+// with message.
+func (cfg *apiConfig) rejectConsent(w http.ResponseWriter, r *http.Request, page consentPage, accountKey, ipKey, message string) {
+	err := cfg.recordFailedLogin(r.Context(), accountKey, ipKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record login attempt", err)
+		return
+	}
+	page.Error = message
+	renderConsent(w, http.StatusUnauthorized, page)
+}
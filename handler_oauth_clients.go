@@ -0,0 +1,191 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/circuit-shell/http-server-go/internal/auth"
+	"github.com/circuit-shell/http-server-go/internal/database"
+)
+
+const MAX_OAUTH_CLIENT_NAME_LENGTH = 100
+const MAX_OAUTH_REDIRECT_URIS = 10
+
+type OAuthClient struct {
+	ID           string    `json:"client_id"`
+	Name         string    `json:"name"`
+	Confidential bool      `json:"confidential"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func oauthClientFromDatabase(client database.OauthClient) OAuthClient {
+	return OAuthClient{
+		ID:           client.ID,
+		Name:         client.Name,
+		Confidential: client.SecretHash.Valid,
+		RedirectURIs: client.RedirectUris,
+		Scopes:       client.Scopes,
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+// validateRedirectURI accepts https URLs, http on the loopback interface
+// for development and private-use schemes such as com.example.app:/cb for
+// native apps (RFC 8252). Redirect URIs are later matched exactly.
+func validateRedirectURI(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() {
+		return fmt.Errorf("redirect URI %q must be an absolute URL", raw)
+	}
+	if u.Fragment != "" || strings.Contains(raw, "#") {
+		return fmt.Errorf("redirect URI %q must not have a fragment", raw)
+	}
+
+	switch u.Scheme {
+	case "https":
+		if u.Host == "" {
+			return fmt.Errorf("redirect URI %q has no host", raw)
+		}
+		return nil
+	case "http":
+		host := u.Hostname()
+		if host == "localhost" || host == "127.0.0.1" || host == "::1" {
+			return nil
+		}
+		return fmt.Errorf("redirect URI %q must use https", raw)
+	}
+	if strings.Contains(u.Scheme, ".") {
+		return nil
+	}
+	return fmt.Errorf("redirect URI %q must use https or a reverse domain name scheme", raw)
+}
+
+func (cfg *apiConfig) handlerCreateOAuthClient(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		Confidential bool     `json:"confidential"`
+	}
+	type response struct {
+		OAuthClient
+		Secret string `json:"client_secret,omitempty"`
+	}
+
+	principal := principalFromRequest(r)
+	if principal.FromKey {
+		respondWithError(w, http.StatusForbidden, "API keys can't register OAuth clients", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" || len(params.Name) > MAX_OAUTH_CLIENT_NAME_LENGTH {
+		respondWithError(w, http.StatusBadRequest, "name is required and must be at most 100 characters", nil)
+		return
+	}
+	if len(params.RedirectURIs) == 0 || len(params.RedirectURIs) > MAX_OAUTH_REDIRECT_URIS {
+		respondWithError(w, http.StatusBadRequest, "between 1 and 10 redirect_uris are required", nil)
+		return
+	}
+	for _, redirectURI := range params.RedirectURIs {
+		err = validateRedirectURI(redirectURI)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+	if len(params.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "at least one scope is required", nil)
+		return
+	}
+	// role scopes are checked per user at authorization time
+	_, err = auth.GrantScopes(params.Scopes, auth.OAuthScopes, auth.RoleAdmin)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "scopes must be among "+strings.Join(auth.OAuthScopes, ", "), err)
+		return
+	}
+
+	clientID, err := auth.MakeClientID()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error generating client ID", err)
+		return
+	}
+	secret := ""
+	secretHash := sql.NullString{}
+	if params.Confidential {
+		secret, err = auth.MakeRefreshToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error generating client secret", err)
+			return
+		}
+		secretHash = sql.NullString{String: auth.HashToken(secret), Valid: true}
+	}
+
+	client, err := cfg.dbQueries.CreateOAuthClient(r.Context(), database.CreateOAuthClientParams{
+		ID:           clientID,
+		OwnerUserID:  principal.UserID,
+		Name:         params.Name,
+		SecretHash:   secretHash,
+		RedirectUris: params.RedirectURIs,
+		Scopes:       auth.ParseScope(strings.Join(params.Scopes, " ")),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error saving OAuth client", err)
+		return
+	}
+
+	// the secret is only ever shown here
+	respondWithJSON(w, http.StatusCreated, response{
+		OAuthClient: oauthClientFromDatabase(client),
+		Secret:      secret,
+	})
+}
+
+func (cfg *apiConfig) handlerListOAuthClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := cfg.dbQueries.GetOAuthClientsByOwner(r.Context(), principalFromRequest(r).UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get OAuth clients", err)
+		return
+	}
+
+	formatted := []OAuthClient{}
+	for _, client := range clients {
+		formatted = append(formatted, oauthClientFromDatabase(client))
+	}
+	respondWithJSON(w, http.StatusOK, formatted)
+}
+
+// handlerDeleteOAuthClient removes a client together with every token
+// issued to it.
+func (cfg *apiConfig) handlerDeleteOAuthClient(w http.ResponseWriter, r *http.Request) {
+	deleted, err := cfg.dbQueries.DeleteOAuthClient(r.Context(), database.DeleteOAuthClientParams{
+		ID:          r.PathValue("clientID"),
+		OwnerUserID: principalFromRequest(r).UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete OAuth client", err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "Couldn't find OAuth client", errors.New("no such client for this owner"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
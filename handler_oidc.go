@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/circuit-shell/http-server-go/internal/auth"
+	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/circuit-shell/http-server-go/internal/oidc"
+)
+
+// OIDC_STATE_COOKIE binds a sign-in to the browser that started it, so
+// nobody can log a victim into the attacker's account by sending them a
+// callback link.
+const OIDC_STATE_COOKIE = "chirpy_oidc_state"
+const OIDC_STATE_MAX_AGE = 10 * 60
+
+// loadOIDCProviders configures the providers named in names, a comma
+// separated list such as "google,gitlab". Each is set up by
+// OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID and OIDC_<NAME>_CLIENT_SECRET,
+// and is registered with the provider using the callback URL below
+// baseURL.
+func loadOIDCProviders(names, baseURL string, getenv func(string) string) (map[string]*oidc.Provider, error) {
+	providers := map[string]*oidc.Provider{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		config := oidc.Config{
+			Issuer:       getenv(prefix + "ISSUER"),
+			ClientID:     getenv(prefix + "CLIENT_ID"),
+			ClientSecret: getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  baseURL + "/api/oidc/" + name + "/callback",
+		}
+		if config.Issuer == "" || config.ClientID == "" {
+			return nil, fmt.Errorf("%sISSUER and %sCLIENT_ID are required", prefix, prefix)
+		}
+		providers[name] = oidc.NewProvider(config, nil)
+	}
+	return providers, nil
+}
+
+// handlerOIDCLogin starts a sign-in with an external provider by sending
+// the browser there.
+func (cfg *apiConfig) handlerOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("provider")
+	provider, ok := cfg.oidcProviders[name]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown sign-in provider", nil)
+		return
+	}
+
+	// state, nonce and PKCE verifier are all unguessable random strings
+	secrets := make([]string, 3)
+	for i := range secrets {
+		secret, err := auth.MakeRefreshToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error starting sign-in", err)
+			return
+		}
+		secrets[i] = secret
+	}
+	state, nonce, verifier := secrets[0], secrets[1], secrets[2]
+
+	err := cfg.dbQueries.DeleteExpiredOIDCStates(r.Context())
+	if err != nil {
+		log.Printf("Error deleting expired OIDC states: %s", err)
+	}
+	err = cfg.dbQueries.CreateOIDCState(r.Context(), database.CreateOIDCStateParams{
+		StateHash:    auth.HashToken(state),
+		Provider:     name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		DeviceName:   newSessionInfo(r, r.URL.Query().Get("device_name")).DeviceName,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting sign-in", err)
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(r.Context(), state, nonce, auth.PKCEChallenge(verifier))
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't reach the sign-in provider", err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDC_STATE_COOKIE,
+		Value:    state,
+		Path:     "/api/oidc/" + name,
+		MaxAge:   OIDC_STATE_MAX_AGE,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.appBaseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handlerOIDCCallback finishes a sign-in: it redeems the code, verifies
+// the ID token and logs in the user the identity belongs to.
+func (cfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("provider")
+	provider, ok := cfg.oidcProviders[name]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown sign-in provider", nil)
+		return
+	}
+
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		respondWithError(w, http.StatusUnauthorized, "Sign-in failed: "+providerErr, nil)
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(OIDC_STATE_COOKIE)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		respondWithError(w, http.StatusBadRequest, "Invalid sign-in state", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDC_STATE_COOKIE,
+		Path:     "/api/oidc/" + name,
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	stored, err := cfg.dbQueries.ConsumeOIDCState(r.Context(), database.ConsumeOIDCStateParams{
+		StateHash: auth.HashToken(state),
+		Provider:  name,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusBadRequest, "Sign-in expired, please try again", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get sign-in state", err)
+		return
+	}
+
+	idToken, err := provider.Exchange(r.Context(), query.Get("code"), stored.CodeVerifier, stored.Nonce)
+	if errors.Is(err, oidc.ErrInvalidIDToken) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid ID token", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't complete sign-in with the provider", err)
+		return
+	}
+
+	user, err := cfg.identityUser(r.Context(), name, idToken)
+	if errors.Is(err, errIdentityEmailUnverified) {
+		respondWithError(w, http.StatusForbidden, "The provider hasn't verified your email address", err)
+		return
+	}
+	if errors.Is(err, errIdentityEmailTaken) {
+		respondWithError(w, http.StatusConflict, "An account with this email already exists; log in with your password", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign in", err)
+		return
+	}
+
+	// the provider replaces the password, not the second factor
+	enabled, err := mfaEnabled(r.Context(), cfg.dbQueries, user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor authentication", err)
+		return
+	}
+	if enabled {
+		cfg.respondWithMFAChallenge(w, user.ID)
+		return
+	}
+
+	authenticated, err := cfg.issueTokens(r.Context(), cfg.dbQueries, user, newSessionInfo(r, stored.DeviceName.String))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error generating tokens", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, authenticated)
+}
+
+var errIdentityEmailUnverified = errors.New("identity has no verified email")
+var errIdentityEmailTaken = errors.New("email belongs to an unverified account")
+
+// identityUser finds the user an external identity belongs to. A new
+// identity is linked to the account with the same email if that account
+// has verified the address too, and gets a new account without a
+// password if there is none.
+func (cfg *apiConfig) identityUser(ctx context.Context, provider string, idToken oidc.IDToken) (database.User, error) {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	identity, err := qtx.GetUserIdentity(ctx, database.GetUserIdentityParams{
+		Provider: provider,
+		Subject:  idToken.Subject,
+	})
+	if err == nil {
+		email := identity.Email
+		if idToken.Email != "" {
+			email = idToken.Email
+		}
+		err = qtx.TouchUserIdentity(ctx, database.TouchUserIdentityParams{
+			Provider: provider,
+			Subject:  idToken.Subject,
+			Email:    email,
+		})
+		if err != nil {
+			return database.User{}, err
+		}
+		user, err := qtx.GetUserByID(ctx, identity.UserID)
+		if err != nil {
+			return database.User{}, err
+		}
+		return user, tx.Commit()
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	// only an address the provider vouches for may claim an account
+	if idToken.Email == "" || !idToken.EmailVerified {
+		return database.User{}, errIdentityEmailUnverified
+	}
+
+	user, err := qtx.GetUserByEmail(ctx, idToken.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		var handle string
+		handle, err = newHandle()
+		if err != nil {
+			return database.User{}, err
+		}
+		user, err = qtx.CreateOIDCUser(ctx, database.CreateOIDCUserParams{
+			Email:  idToken.Email,
+			Handle: handle,
+		})
+	} else if err == nil && !user.EmailVerifiedAt.Valid {
+		// whoever signed up with the address never proved they own it
+		return database.User{}, errIdentityEmailTaken
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+
+	_, err = qtx.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		Provider: provider,
+		Subject:  idToken.Subject,
+		UserID:   user.ID,
+		Email:    idToken.Email,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	return user, tx.Commit()
+}
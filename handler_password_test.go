@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/circuit-shell/http-server-go/internal/mailer"
+)
+
+func TestPasswordResetFlow(t *testing.T) {
+	db := testDB(t)
+	q := database.New(db)
+	passwords, err := loadPasswordPolicy("", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mail := mailer.NewMemoryMailer()
+	cfg := &apiConfig{
+		db:         db,
+		dbQueries:  q,
+		mailer:     mail,
+		passwords:  passwords,
+		appBaseURL: "https://chirpy.example",
+	}
+	user := createTestUser(t, q, "walter")
+
+	post := func(handler http.HandlerFunc, body string) int {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, "/api/password", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	// unknown and known emails get the same answer
+	for _, email := range []string{"nobody@example.com", user.Email} {
+		if code := post(cfg.handlerPasswordForgot, `{"email": "`+email+`"}`); code != http.StatusAccepted {
+			t.Fatalf("forgot %s: status = %d, want %d", email, code, http.StatusAccepted)
+		}
+	}
+
+	// the mail goes out in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for len(mail.Messages()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	messages := mail.Messages()
+	if len(messages) != 1 || messages[0].To != user.Email {
+		t.Fatalf("sent %+v, want one mail to %s", messages, user.Email)
+	}
+	match := regexp.MustCompile(`token=(\S+)`).FindStringSubmatch(messages[0].Body)
+	if match == nil {
+		t.Fatalf("no reset link in %q", messages[0].Body)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reset := `{"token": "` + token + `", "password": "say my name"}`
+	if code := post(cfg.handlerPasswordReset, reset); code != http.StatusNoContent {
+		t.Fatalf("reset: status = %d, want %d", code, http.StatusNoContent)
+	}
+	user, err = q.GetUserByID(context.Background(), user.ID)
+	if err != nil {
+		t.Fatalf("getting user: %v", err)
+	}
+	if ok, _ := passwords.Check("say my name", user.HashedPassword); !ok {
+		t.Error("password wasn't changed")
+	}
+
+	reuse := `{"token": "` + token + `", "password": "stolen"}`
+	if code := post(cfg.handlerPasswordReset, reuse); code != http.StatusBadRequest {
+		t.Errorf("reusing the token: status = %d, want %d", code, http.StatusBadRequest)
+	}
+}
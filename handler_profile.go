@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const MAX_AVATAR_URL_LENGTH = 2048
+
+// the database enforces the same pattern
+var HANDLE_PATTERN = regexp.MustCompile(`^[A-Za-z0-9_]{3,30}$`)
+
+// handles that would be confused with routes or staff
+var RESERVED_HANDLES = []string{"admin", "api", "chirpy", "me", "moderator", "root", "support", "system"}
+
+func validateHandle(handle string) error {
+	if !HANDLE_PATTERN.MatchString(handle) {
+		return errors.New("handle must be 3 to 30 letters, digits or underscores")
+	}
+	if slices.Contains(RESERVED_HANDLES, strings.ToLower(handle)) {
+		return fmt.Errorf("handle %q is reserved", handle)
+	}
+	return nil
+}
+
+// newHandle is the placeholder handle of users who didn't pick one.
+func newHandle() (string, error) {
+	b := make([]byte, 6)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return "user_" + hex.EncodeToString(b), nil
+}
+
+func validateAvatarURL(avatarURL string) error {
+	if avatarURL == "" {
+		return nil
+	}
+	u, err := url.Parse(avatarURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" || len(avatarURL) > MAX_AVATAR_URL_LENGTH {
+		return errors.New("avatar_url must be an https URL")
+	}
+	return nil
+}
+
+// Profile is the public view of a user; it never includes the email.
+type Profile struct {
+	ID             uuid.UUID `json:"id"`
+	Handle         string    `json:"handle"`
+	DisplayName    string    `json:"display_name"`
+	Bio            string    `json:"bio"`
+	AvatarURL      string    `json:"avatar_url"`
+	FollowerCount  int32     `json:"follower_count"`
+	FollowingCount int32     `json:"following_count"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func (cfg *apiConfig) handlerGetProfile(w http.ResponseWriter, r *http.Request) {
+	handle := strings.TrimPrefix(r.PathValue("handle"), "@")
+
+	user, err := cfg.dbQueries.GetUserByHandle(r.Context(), handle)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Profile{
+		ID:             user.ID,
+		Handle:         user.Handle,
+		DisplayName:    user.DisplayName,
+		Bio:            user.Bio,
+		AvatarURL:      user.AvatarUrl,
+		FollowerCount:  user.FollowerCount,
+		FollowingCount: user.FollowingCount,
+		CreatedAt:      user.CreatedAt,
+	})
+}
+
+// Author is the compact user embedded in chirps with ?expand=author.
+type Author struct {
+	ID          uuid.UUID `json:"id"`
+	Handle      string    `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	AvatarURL   string    `json:"avatar_url"`
+}
+
+// parseExpandAuthor reads ?expand=, which asks for related objects to be
+// embedded; author is the only one so far.
+func parseExpandAuthor(expand string) (bool, error) {
+	if expand == "" {
+		return false, nil
+	}
+	for _, field := range strings.Split(expand, ",") {
+		if field != "author" {
+			return false, fmt.Errorf("can't expand %q", field)
+		}
+	}
+	return true, nil
+}
+
+// embedAuthors fills in the author of every chirp with a single query.
+func (cfg *apiConfig) embedAuthors(ctx context.Context, chirps []Chirp) error {
+	ids := []uuid.UUID{}
+	for _, chirp := range chirps {
+		if !slices.Contains(ids, chirp.UserID) {
+			ids = append(ids, chirp.UserID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	profiles, err := cfg.dbQueries.GetUserProfilesByIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+	authors := map[uuid.UUID]*Author{}
+	for _, profile := range profiles {
+		authors[profile.ID] = &Author{
+			ID:          profile.ID,
+			Handle:      profile.Handle,
+			DisplayName: profile.DisplayName,
+			AvatarURL:   profile.AvatarUrl,
+		}
+	}
+	for i := range chirps {
+		chirps[i].Author = authors[chirps[i].UserID]
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/circuit-shell/http-server-go/internal/auth"
+	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+const MAX_USER_AGENT_LENGTH = 512
+const MAX_DEVICE_NAME_LENGTH = 100
+
+// sessionInfo describes the device a refresh token family was issued to.
+type sessionInfo struct {
+	UserAgent  string
+	IPAddress  string
+	DeviceName sql.NullString
+}
+
+func newSessionInfo(r *http.Request, deviceName string) sessionInfo {
+	info := sessionInfo{
+		UserAgent: truncate(r.UserAgent(), MAX_USER_AGENT_LENGTH),
+		IPAddress: clientIP(r),
+	}
+	if deviceName != "" {
+		info.DeviceName = sql.NullString{String: truncate(deviceName, MAX_DEVICE_NAME_LENGTH), Valid: true}
+	}
+	return info
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
+
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	DeviceName string    `json:"device_name,omitempty"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+func (cfg *apiConfig) handlerListSessions(w http.ResponseWriter, r *http.Request) {
+	principal := principalFromRequest(r)
+
+	rows, err := cfg.dbQueries.GetActiveSessions(r.Context(), principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get sessions", err)
+		return
+	}
+
+	sessions := []Session{}
+	for _, row := range rows {
+		sessions = append(sessions, Session{
+			ID:         row.FamilyID,
+			DeviceName: row.DeviceName.String,
+			UserAgent:  row.UserAgent,
+			IPAddress:  row.IpAddress,
+			CreatedAt:  row.SessionStartedAt,
+			LastUsedAt: row.LastUsedAt,
+			ExpiresAt:  row.ExpiresAt,
+			Current:    row.FamilyID == principal.SessionID,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+// handlerRevokeSession logs one device out. Its refresh token stops
+// working at once; access tokens it already holds run out on their own.
+func (cfg *apiConfig) handlerRevokeSession(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid session ID", err)
+		return
+	}
+
+	// scoped to the caller, so other users' sessions look like unknown ones
+	revoked, err := cfg.dbQueries.RevokeSession(r.Context(), database.RevokeSessionParams{
+		FamilyID: sessionID,
+		UserID:   principalFromRequest(r).UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "Couldn't find session", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeOtherSessions logs the user out everywhere but in the caller's
+// own session, or everywhere if the caller has no session, as with API
+// keys.
+func (cfg *apiConfig) revokeOtherSessions(ctx context.Context, q *database.Queries, principal auth.Principal) error {
+	_, err := q.RevokeOtherSessions(ctx, database.RevokeOtherSessionsParams{
+		UserID:   principal.UserID,
+		FamilyID: principal.SessionID,
+	})
+	return err
+}
+
+func (cfg *apiConfig) handlerRevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	principal := principalFromRequest(r)
+	if principal.SessionID == uuid.Nil {
+		respondWithError(w, http.StatusBadRequest, "Access token isn't tied to a session, log in again", nil)
+		return
+	}
+
+	err := cfg.revokeOtherSessions(r.Context(), cfg.dbQueries, principal)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+// Chirps by accounts with at most this many followers are copied into
+// every follower's timeline by the fan-out workers, shortly after they are
+// posted. Chirps by bigger accounts would take too long to copy, so
+// timelines look them up when they are read instead.
+const FANOUT_MAX_FOLLOWERS = 10000
+
+// how many of an account's latest chirps show up in a timeline right
+// after following it
+const TIMELINE_BACKFILL = 100
+
+// handlerTimeline lists chirps by the accounts the caller follows, newest
+// first.
+func (cfg *apiConfig) handlerTimeline(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	pageSize, err := parsePageSize(query.Get("limit"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	withAuthors, err := parseExpandAuthor(query.Get("expand"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	userID := principalFromRequest(r).UserID
+	params := database.GetTimelinePageParams{
+		UserID: userID,
+		// fetch one extra row to find out whether there is a next page
+		PageSize: int32(pageSize + 1),
+	}
+	if cursorStr := query.Get("cursor"); cursorStr != "" {
+		cursor, err := decodeCursor(cursorStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+			return
+		}
+		params.CursorCreatedAt = sql.NullTime{Time: cursor.CreatedAt, Valid: true}
+		params.CursorID = uuid.NullUUID{UUID: cursor.ID, Valid: true}
+	}
+
+	timeline, err := cfg.dbQueries.GetTimelinePage(r.Context(), params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading timeline", err)
+		return
+	}
+
+	page := chirpsPage{
+		Chirps: []Chirp{},
+	}
+	if len(timeline) > pageSize {
+		timeline = timeline[:pageSize]
+		last := timeline[len(timeline)-1]
+		page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+
+	for _, chirp := range timeline {
+		page.Chirps = append(page.Chirps, chirpFromDatabase(chirp))
+	}
+	err = cfg.embedMedia(r.Context(), page.Chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading media", err)
+		return
+	}
+	err = cfg.embedLikes(r.Context(), userID, page.Chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading likes", err)
+		return
+	}
+	if withAuthors {
+		err = cfg.embedAuthors(r.Context(), page.Chirps)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error reading authors", err)
+			return
+		}
+	}
+	err = cfg.embedOriginals(r.Context(), userID, withAuthors, page.Chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading original chirps", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, page)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestTimeline(t *testing.T) {
+	db := testDB(t)
+	q := database.New(db)
+	cfg := &apiConfig{db: db, dbQueries: q}
+	ctx := context.Background()
+
+	follow := func(follower, followee database.User) {
+		t.Helper()
+		_, err := q.FollowUser(ctx, database.FollowUserParams{FollowerID: follower.ID, FolloweeID: followee.ID})
+		if err != nil {
+			t.Fatalf("following: %v", err)
+		}
+		err = q.AdjustFollowCounts(ctx, database.AdjustFollowCountsParams{
+			FollowerID: follower.ID,
+			FolloweeID: followee.ID,
+			Delta:      1,
+		})
+		if err != nil {
+			t.Fatalf("counting follow: %v", err)
+		}
+		err = q.BackfillTimeline(ctx, database.BackfillTimelineParams{
+			UserID:     follower.ID,
+			AuthorID:   followee.ID,
+			MaxEntries: TIMELINE_BACKFILL,
+		})
+		if err != nil {
+			t.Fatalf("backfilling timeline: %v", err)
+		}
+	}
+	// post queues a chirp for fan-out, as handlerCreateChirp does
+	post := func(author database.User, body string) uuid.UUID {
+		t.Helper()
+		chirp, err := q.CreateChirp(ctx, database.CreateChirpParams{Body: body, UserID: author.ID})
+		if err != nil {
+			t.Fatalf("creating chirp: %v", err)
+		}
+		err = q.QueueFanOut(ctx, chirp.ID)
+		if err != nil {
+			t.Fatalf("queueing fan-out: %v", err)
+		}
+		return chirp.ID
+	}
+	timeline := func(user database.User) []uuid.UUID {
+		t.Helper()
+		chirps, err := q.GetTimelinePage(ctx, database.GetTimelinePageParams{UserID: user.ID, PageSize: 10})
+		if err != nil {
+			t.Fatalf("reading timeline: %v", err)
+		}
+		ids := []uuid.UUID{}
+		for _, chirp := range chirps {
+			ids = append(ids, chirp.ID)
+		}
+		return ids
+	}
+
+	author := createTestUser(t, q, "author")
+	celebrity := createTestUser(t, q, "celebrity")
+	fan := createTestUser(t, q, "fan")
+	follow(fan, author)
+	follow(fan, celebrity)
+	// too many followers to fan out to
+	_, err := db.ExecContext(ctx, "UPDATE users SET follower_count = $1 WHERE id = $2", FANOUT_MAX_FOLLOWERS+1, celebrity.ID)
+	if err != nil {
+		t.Fatalf("setting follower_count: %v", err)
+	}
+
+	first := post(author, "first")
+	if got := timeline(fan); !slices.Equal(got, []uuid.UUID{first}) {
+		t.Errorf("before fan-out: timeline = %v, want %v", got, []uuid.UUID{first})
+	}
+	err = cfg.fanOut(ctx, first)
+	if err != nil {
+		t.Fatalf("fanning out: %v", err)
+	}
+	if got := timeline(fan); !slices.Equal(got, []uuid.UUID{first}) {
+		t.Errorf("after fan-out: timeline = %v, want %v", got, []uuid.UUID{first})
+	}
+
+	famous := post(celebrity, "famous")
+	err = cfg.fanOut(ctx, famous)
+	if err != nil {
+		t.Fatalf("fanning out: %v", err)
+	}
+	if got := timeline(fan); !slices.Equal(got, []uuid.UUID{famous, first}) {
+		t.Errorf("big account: timeline = %v, want %v", got, []uuid.UUID{famous, first})
+	}
+
+	latecomer := createTestUser(t, q, "latecomer")
+	follow(latecomer, author)
+	if got := timeline(latecomer); !slices.Equal(got, []uuid.UUID{first}) {
+		t.Errorf("backfill: timeline = %v, want %v", got, []uuid.UUID{first})
+	}
+
+	_, err = q.UnfollowUser(ctx, database.UnfollowUserParams{FollowerID: fan.ID, FolloweeID: author.ID})
+	if err != nil {
+		t.Fatalf("unfollowing: %v", err)
+	}
+	err = q.RemoveFromTimeline(ctx, database.RemoveFromTimelineParams{UserID: fan.ID, AuthorID: author.ID})
+	if err != nil {
+		t.Fatalf("removing from timeline: %v", err)
+	}
+	if got := timeline(fan); !slices.Equal(got, []uuid.UUID{famous}) {
+		t.Errorf("after unfollowing: timeline = %v, want %v", got, []uuid.UUID{famous})
+	}
+}
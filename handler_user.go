@@ -1,21 +1,49 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
 	"time"
+	"unicode/utf8"
 
-	"github.com/circuit-shell/http-server-go/internal/auth"
 	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/circuit-shell/http-server-go/internal/mailer"
 	"github.com/google/uuid"
 )
 
 type User struct {
-	ID          uuid.UUID `json:"id"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	Email       string    `json:"email"`
-	IsChirpyRed bool      `json:"is_chirpy_red"`
+	ID            uuid.UUID `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	PendingEmail  string    `json:"pending_email,omitempty"`
+	IsChirpyRed   bool      `json:"is_chirpy_red"`
+	Role          string    `json:"role"`
+	Handle        string    `json:"handle"`
+	DisplayName   string    `json:"display_name"`
+	Bio           string    `json:"bio"`
+	AvatarURL     string    `json:"avatar_url"`
+}
+
+func userFromDatabase(user database.User) User {
+	return User{
+		ID:            user.ID,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		PendingEmail:  user.PendingEmail.String,
+		IsChirpyRed:   user.IsChirpyRed,
+		Role:          user.Role,
+		Handle:        user.Handle,
+		DisplayName:   user.DisplayName,
+		Bio:           user.Bio,
+		AvatarURL:     user.AvatarUrl,
+	}
 }
 
 type userInput struct {
@@ -24,88 +52,450 @@ type userInput struct {
 }
 
 func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		userInput
+		Handle string `json:"handle"`
+	}
+
 	decoder := json.NewDecoder(r.Body)
-	userParams := userInput{}
+	userParams := parameters{}
 	err := decoder.Decode(&userParams)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error decoding user params", err)
 		return
 	}
 
-	hashedPw, err := auth.HashPassword(userParams.Password)
+	// a handle is optional at sign-up and can be picked later
+	if userParams.Handle == "" {
+		userParams.Handle, err = newHandle()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error generating handle", err)
+			return
+		}
+	}
+	err = validateHandle(userParams.Handle)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	hashedPw, err := cfg.passwords.Hash(userParams.Password)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error hashing password", err)
 		return
 	}
 
-	user, err := cfg.dbQueries.CreateUser(r.Context(), database.CreateUserParams{
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting transaction", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	user, err := qtx.CreateUser(r.Context(), database.CreateUserParams{
 		Email:          userParams.Email,
 		HashedPassword: hashedPw,
+		Handle:         userParams.Handle,
 	})
+	if isConstraintViolation(err, "users_handle_lower_idx") {
+		respondWithError(w, http.StatusConflict, "Handle already taken", err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Error creating user", err)
 		return
 	}
-	respondWithJSON(w, http.StatusCreated, User{
-		ID:          user.ID,
-		CreatedAt:   user.CreatedAt,
-		UpdatedAt:   user.CreatedAt,
-		Email:       user.Email,
-		IsChirpyRed: user.IsChirpyRed,
-	})
+
+	verificationMail, err := cfg.prepareEmailVerification(r.Context(), qtx, user.ID, user.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating verification token", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating user", err)
+		return
+	}
+	cfg.sendMail(verificationMail)
+
+	respondWithJSON(w, http.StatusCreated, userFromDatabase(user))
 }
 
+// handlerUpdateUser replaces the email and password; PATCH /api/users/me
+// is the partial way of doing the same. Both need confirmIdentity.
 func (cfg *apiConfig) handlerUpdateUser(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		userInput
+		identityProof
+	}
+
+	userID := principalFromRequest(r).UserID
+
+	decoder := json.NewDecoder(r.Body)
+	userParams := parameters{}
 
-	// get the token from the request
-	token, err := auth.GetBearerToken(r.Header)
+	err := decoder.Decode(&userParams)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Error getting token", err)
+		respondWithError(w, http.StatusInternalServerError, "Error decoding user params", err)
 		return
 	}
 
-	// validate the token
-	userID, err := auth.ValidateJWT(token, cfg.serverSecret)
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Error validating token", err)
+		respondWithError(w, http.StatusInternalServerError, "Error updating user", err)
+		return
+	}
+	if !cfg.confirmIdentity(w, r, user, userParams.identityProof) {
 		return
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	userParams := userInput{}
+	hashedPw := ""
+	if userParams.Password != "" {
+		hashedPw, err = cfg.passwords.Hash(userParams.Password)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error hashing password", err)
+			return
+		}
+	}
 
-	err = decoder.Decode(&userParams)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error decoding user params", err)
+	// accounts created through an OIDC provider have no password and may
+	// keep it that way, but a real password is never replaced by an empty one
+	if hashedPw == "" && user.HashedPassword != "" {
+		respondWithError(w, http.StatusBadRequest, "Password is required", nil)
 		return
 	}
 
-	hashedPw, err := auth.HashPassword(userParams.Password)
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error hashing password", err)
+		respondWithError(w, http.StatusInternalServerError, "Error starting transaction", err)
 		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
 
+	if hashedPw != "" {
+		user, err = qtx.PatchUser(r.Context(), database.PatchUserParams{
+			ID:             userID,
+			HashedPassword: sql.NullString{String: hashedPw, Valid: true},
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error updating user", err)
+			return
+		}
+		err = cfg.revokeOtherSessions(r.Context(), qtx, principalFromRequest(r))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error revoking sessions", err)
+			return
+		}
 	}
 
-	user, err := cfg.dbQueries.UpdateUser(r.Context(), database.UpdateUserParams{
-		ID:             userID,
-		Email:          userParams.Email,
-		HashedPassword: hashedPw,
+	// a new email stays pending until the address has been confirmed
+	oldEmail := user.Email
+	var verificationMail *mailer.Message
+	if userParams.Email != "" && userParams.Email != user.Email {
+		var msg mailer.Message
+		user, msg, err = cfg.prepareEmailChange(r.Context(), qtx, userID, userParams.Email)
+		if errors.Is(err, errEmailTaken) {
+			respondWithError(w, http.StatusConflict, "Email already in use", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error updating email", err)
+			return
+		}
+		verificationMail = &msg
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error updating user", err)
+		return
+	}
+	if verificationMail != nil {
+		cfg.sendMail(*verificationMail)
+		cfg.sendMail(emailChangeNotice(oldEmail, userParams.Email))
+	}
+
+	respondWithJSON(w, http.StatusOK, userFromDatabase(user))
+
+}
+
+// REAUTH_WINDOW is how recently an account without a password must have
+// signed in to change its email or set a password without an MFA code.
+const REAUTH_WINDOW = 10 * time.Minute
+
+// identityProof is what a caller gives to change the email or password:
+// the current password, or for accounts without one an MFA code.
+type identityProof struct {
+	CurrentPassword string `json:"current_password"`
+	MFACode         string `json:"mfa_code"`
+}
+
+// confirmIdentity guards email and password changes, so a stolen access
+// token can't take over the account. Unless proof confirms the user it
+// responds with an error and returns false.
+func (cfg *apiConfig) confirmIdentity(w http.ResponseWriter, r *http.Request, user database.User, proof identityProof) bool {
+	if user.HashedPassword != "" {
+		return cfg.confirmCurrentPassword(w, r, user, proof.CurrentPassword)
+	}
+	// accounts created through an OIDC provider have no password; they
+	// confirm with an MFA code, or by having just signed in
+	if proof.MFACode != "" {
+		return cfg.confirmMFACode(w, r, user, proof.MFACode)
+	}
+	recent, err := cfg.signedInRecently(r)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check session", err)
+		return false
+	}
+	if !recent {
+		respondWithError(w, http.StatusForbidden, "Sign in again or give mfa_code to change email or password", nil)
+		return false
+	}
+	return true
+}
+
+// signedInRecently reports whether the caller's first-party session began
+// within REAUTH_WINDOW. API keys and third-party clients never count.
+func (cfg *apiConfig) signedInRecently(r *http.Request) (bool, error) {
+	principal := principalFromRequest(r)
+	if principal.SessionID == uuid.Nil || principal.FromKey || principal.ClientID != "" {
+		return false, nil
+	}
+	startedAt, err := cfg.dbQueries.GetSessionStartedAt(r.Context(), database.GetSessionStartedAtParams{
+		FamilyID: principal.SessionID,
+		UserID:   principal.UserID,
 	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return time.Now().UTC().Sub(startedAt) <= REAUTH_WINDOW, nil
+}
 
+// confirmCurrentPassword responds with an error and returns false unless
+// currentPassword is the user's password.
+func (cfg *apiConfig) confirmCurrentPassword(w http.ResponseWriter, r *http.Request, user database.User, currentPassword string) bool {
+	if currentPassword == "" {
+		respondWithError(w, http.StatusForbidden, "current_password is required to change email or password", nil)
+		return false
+	}
+
+	accountKey, ipKey := accountLoginKey(user.Email), ipLoginKey(r)
+	wait, err := cfg.loginRetryAfter(r.Context(), accountKey, ipKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check login attempts", err)
+		return false
+	}
+	if wait > 0 {
+		respondTooManyAttempts(w, wait)
+		return false
+	}
+	// a wrong guess counts against the same backoff as a failed login
+	ok, _ := cfg.passwords.Check(currentPassword, user.HashedPassword)
+	if !ok {
+		err = cfg.recordFailedLogin(r.Context(), accountKey, ipKey)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record login attempt", err)
+			return false
+		}
+		respondWithError(w, http.StatusForbidden, "Incorrect current password", nil)
+		return false
+	}
+	return true
+}
+
+// confirmMFACode responds with an error and returns false unless code is
+// a current TOTP code or an unused recovery code of the user.
+func (cfg *apiConfig) confirmMFACode(w http.ResponseWriter, r *http.Request, user database.User, code string) bool {
+	accountKey, ipKey := accountLoginKey(user.Email), ipLoginKey(r)
+	wait, err := cfg.loginRetryAfter(r.Context(), accountKey, ipKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check login attempts", err)
+		return false
+	}
+	if wait > 0 {
+		respondTooManyAttempts(w, wait)
+		return false
+	}
+
+	totp, err := cfg.dbQueries.GetUserTOTP(r.Context(), user.ID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !totp.ConfirmedAt.Valid) {
+		respondWithError(w, http.StatusForbidden, "MFA is not enabled; sign in again to change email or password", nil)
+		return false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get MFA settings", err)
+		return false
+	}
+	ok, err := checkSecondFactor(r.Context(), cfg.dbQueries, totp, code)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+		return false
+	}
+	if !ok {
+		err = cfg.recordFailedLogin(r.Context(), accountKey, ipKey)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record login attempt", err)
+			return false
+		}
+		respondWithError(w, http.StatusForbidden, "Invalid code", nil)
+		return false
+	}
+	return true
+}
+
+const MAX_DISPLAY_NAME_LENGTH = 50
+const MAX_BIO_LENGTH = 160
+
+// handlerPatchUser updates only the fields present in the body. Changing
+// the email or the password takes confirmIdentity as well, so a stolen
+// access token can't take over the account.
+func (cfg *apiConfig) handlerPatchUser(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email       *string `json:"email"`
+		Password    *string `json:"password"`
+		DisplayName *string `json:"display_name"`
+		Bio         *string `json:"bio"`
+		Handle      *string `json:"handle"`
+		AvatarURL   *string `json:"avatar_url"`
+		identityProof
+	}
+
+	principal := principalFromRequest(r)
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	patch := database.PatchUserParams{ID: principal.UserID}
+	if params.DisplayName != nil {
+		displayName := strings.TrimSpace(*params.DisplayName)
+		if utf8.RuneCountInString(displayName) > MAX_DISPLAY_NAME_LENGTH {
+			respondWithError(w, http.StatusBadRequest, "display_name must be at most 50 characters", nil)
+			return
+		}
+		patch.DisplayName = sql.NullString{String: displayName, Valid: true}
+	}
+	if params.Bio != nil {
+		bio := strings.TrimSpace(*params.Bio)
+		if utf8.RuneCountInString(bio) > MAX_BIO_LENGTH {
+			respondWithError(w, http.StatusBadRequest, "bio must be at most 160 characters", nil)
+			return
+		}
+		patch.Bio = sql.NullString{String: bio, Valid: true}
+	}
+	if params.Handle != nil {
+		err = validateHandle(*params.Handle)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		patch.Handle = sql.NullString{String: *params.Handle, Valid: true}
+	}
+	if params.AvatarURL != nil {
+		avatarURL := strings.TrimSpace(*params.AvatarURL)
+		err = validateAvatarURL(avatarURL)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		patch.AvatarUrl = sql.NullString{String: avatarURL, Valid: true}
+	}
+	if params.Password != nil && *params.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "password must not be empty", nil)
+		return
+	}
+	if params.Email != nil && strings.TrimSpace(*params.Email) == "" {
+		respondWithError(w, http.StatusBadRequest, "email must not be empty", nil)
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	newEmail := ""
+	if params.Email != nil && strings.TrimSpace(*params.Email) != user.Email {
+		newEmail = strings.TrimSpace(*params.Email)
+	}
+
+	if newEmail != "" || params.Password != nil {
+		if !cfg.confirmIdentity(w, r, user, params.identityProof) {
+			return
+		}
+	}
+
+	if params.Password != nil {
+		hashedPw, err := cfg.passwords.Hash(*params.Password)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error hashing password", err)
+			return
+		}
+		patch.HashedPassword = sql.NullString{String: hashedPw, Valid: true}
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting transaction", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	user, err = qtx.PatchUser(r.Context(), patch)
+	if isConstraintViolation(err, "users_handle_lower_idx") {
+		respondWithError(w, http.StatusConflict, "Handle already taken", err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error updating user", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, User{
-		ID:          user.ID,
-		CreatedAt:   user.CreatedAt,
-		UpdatedAt:   user.CreatedAt,
-		IsChirpyRed: user.IsChirpyRed,
-		Email:       user.Email,
-	})
+	// a new email stays pending until the address has been confirmed
+	oldEmail := user.Email
+	var verificationMail *mailer.Message
+	if newEmail != "" {
+		var msg mailer.Message
+		user, msg, err = cfg.prepareEmailChange(r.Context(), qtx, user.ID, newEmail)
+		if errors.Is(err, errEmailTaken) {
+			respondWithError(w, http.StatusConflict, "Email already in use", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error updating email", err)
+			return
+		}
+		verificationMail = &msg
+	}
+
+	if patch.HashedPassword.Valid {
+		err = cfg.revokeOtherSessions(r.Context(), qtx, principal)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error revoking sessions", err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error updating user", err)
+		return
+	}
+	if verificationMail != nil {
+		cfg.sendMail(*verificationMail)
+		cfg.sendMail(emailChangeNotice(oldEmail, newEmail))
+	}
 
+	respondWithJSON(w, http.StatusOK, userFromDatabase(user))
 }
 
 // func (cfg *apiConfig) handlerReadUsers(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/circuit-shell/http-server-go/internal/auth"
+	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+// userConnector is a database that answers every query with one user row,
+// which is all a handler reads before it asks for current_password.
+type userConnector struct {
+	user database.User
+}
+
+func (c userConnector) Connect(context.Context) (driver.Conn, error) { return userConn(c), nil }
+func (c userConnector) Driver() driver.Driver                        { return nil }
+
+type userConn userConnector
+
+func (c userConn) Prepare(string) (driver.Stmt, error) { return userStmt(c), nil }
+func (c userConn) Close() error                        { return nil }
+func (c userConn) Begin() (driver.Tx, error)           { return nil, errors.New("no transactions") }
+
+type userStmt userConn
+
+func (s userStmt) Close() error                               { return nil }
+func (s userStmt) NumInput() int                              { return -1 }
+func (s userStmt) Exec([]driver.Value) (driver.Result, error) { return nil, errors.New("read only") }
+func (s userStmt) Query([]driver.Value) (driver.Rows, error) {
+	return &userRows{user: s.user}, nil
+}
+
+type userRows struct {
+	user database.User
+	done bool
+}
+
+func (r *userRows) Columns() []string {
+	return []string{"id", "created_at", "updated_at", "email", "hashed_password", "is_chirpy_red", "role",
+		"email_verified_at", "pending_email", "display_name", "bio", "handle", "avatar_url",
+		"follower_count", "following_count"}
+}
+
+func (r *userRows) Close() error { return nil }
+
+func (r *userRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	u := r.user
+	copy(dest, []driver.Value{u.ID.String(), u.CreatedAt, u.UpdatedAt, u.Email, u.HashedPassword, u.IsChirpyRed, u.Role,
+		nil, nil, u.DisplayName, u.Bio, u.Handle, u.AvatarUrl,
+		int64(u.FollowerCount), int64(u.FollowingCount)})
+	return nil
+}
+
+func TestUserChangesNeedCurrentPassword(t *testing.T) {
+	user := database.User{
+		ID:             uuid.New(),
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+		Email:          "walter@example.com",
+		HashedPassword: "$argon2id$v=19$m=65536,t=1,p=4$c2FsdA$aGFzaA",
+		Role:           "user",
+		Handle:         "heisenberg",
+	}
+	db := sql.OpenDB(userConnector{user: user})
+	defer db.Close()
+	cfg := &apiConfig{db: db, dbQueries: database.New(db)}
+
+	tests := []struct {
+		name    string
+		method  string
+		body    string
+		handler http.HandlerFunc
+	}{
+		{
+			name:    "PUT email and password",
+			method:  http.MethodPut,
+			body:    `{"email": "stolen@example.com", "password": "hunter2"}`,
+			handler: cfg.handlerUpdateUser,
+		},
+		{
+			name:    "PUT password only",
+			method:  http.MethodPut,
+			body:    `{"password": "hunter2"}`,
+			handler: cfg.handlerUpdateUser,
+		},
+		{
+			name:    "PUT empty current_password",
+			method:  http.MethodPut,
+			body:    `{"email": "stolen@example.com", "password": "hunter2", "current_password": ""}`,
+			handler: cfg.handlerUpdateUser,
+		},
+		{
+			name:    "PATCH email",
+			method:  http.MethodPatch,
+			body:    `{"email": "stolen@example.com"}`,
+			handler: cfg.handlerPatchUser,
+		},
+		{
+			name:    "PATCH password",
+			method:  http.MethodPatch,
+			body:    `{"password": "hunter2"}`,
+			handler: cfg.handlerPatchUser,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/users", strings.NewReader(tt.body))
+			r = r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{UserID: user.ID}))
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+
+			if w.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want %d; body %s", w.Code, http.StatusForbidden, w.Body)
+			}
+		})
+	}
+}
+
+func TestPasswordlessUserChangesNeedFreshSignIn(t *testing.T) {
+	// created through an OIDC provider, so there's no password to confirm
+	user := database.User{
+		ID:        uuid.New(),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		Email:     "jesse@example.com",
+		Role:      "user",
+		Handle:    "capncook",
+	}
+	db := sql.OpenDB(userConnector{user: user})
+	defer db.Close()
+	cfg := &apiConfig{db: db, dbQueries: database.New(db)}
+
+	tests := []struct {
+		name      string
+		method    string
+		body      string
+		principal auth.Principal
+		handler   http.HandlerFunc
+	}{
+		{
+			name:      "PUT email without a session",
+			method:    http.MethodPut,
+			body:      `{"email": "stolen@example.com"}`,
+			principal: auth.NewPrincipal(user.ID, auth.Role(user.Role)),
+			handler:   cfg.handlerUpdateUser,
+		},
+		{
+			name:      "PUT password with an API key",
+			method:    http.MethodPut,
+			body:      `{"password": "hunter2"}`,
+			principal: auth.NewAPIKeyPrincipal(user.ID, auth.Role(user.Role), nil).WithSession(uuid.New()),
+			handler:   cfg.handlerUpdateUser,
+		},
+		{
+			name:      "PATCH email from a third-party client",
+			method:    http.MethodPatch,
+			body:      `{"email": "stolen@example.com"}`,
+			principal: auth.NewOAuthPrincipal(user.ID, auth.Role(user.Role), nil, "client").WithSession(uuid.New()),
+			handler:   cfg.handlerPatchUser,
+		},
+		{
+			name:      "PATCH password with an empty mfa_code",
+			method:    http.MethodPatch,
+			body:      `{"password": "hunter2", "mfa_code": ""}`,
+			principal: auth.NewPrincipal(user.ID, auth.Role(user.Role)),
+			handler:   cfg.handlerPatchUser,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/users", strings.NewReader(tt.body))
+			r = r.WithContext(auth.WithPrincipal(r.Context(), tt.principal))
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+
+			if w.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want %d; body %s", w.Code, http.StatusForbidden, w.Body)
+			}
+		})
+	}
+}
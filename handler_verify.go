@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/circuit-shell/http-server-go/internal/auth"
+	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/circuit-shell/http-server-go/internal/mailer"
+	"github.com/google/uuid"
+)
+
+var errEmailTaken = errors.New("email already in use")
+
+// prepareEmailVerification stores a fresh verification token for email,
+// invalidating older ones, and returns the mail to send once the caller's
+// transaction has committed.
+func (cfg *apiConfig) prepareEmailVerification(ctx context.Context, q *database.Queries, userID uuid.UUID, email string) (mailer.Message, error) {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return mailer.Message{}, err
+	}
+
+	err = q.InvalidateEmailVerificationTokens(ctx, userID)
+	if err != nil {
+		return mailer.Message{}, err
+	}
+	_, err = q.CreateEmailVerificationToken(ctx, database.CreateEmailVerificationTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    userID,
+		Email:     email,
+	})
+	if err != nil {
+		return mailer.Message{}, err
+	}
+
+	verifyURL := cfg.appBaseURL + "/verify-email?token=" + url.QueryEscape(token)
+	return mailer.Message{
+		To:      email,
+		Subject: "Confirm your email address for Chirpy",
+		Body: fmt.Sprintf("Open this link within the next 24 hours to confirm %s:\n%s\n\n"+
+			"If you didn't ask for this, you can ignore this email.\n", email, verifyURL),
+	}, nil
+}
+
+// emailChangeNotice tells the current address that the login email is
+// about to change, so the owner notices if it wasn't them.
+func emailChangeNotice(oldEmail, newEmail string) mailer.Message {
+	return mailer.Message{
+		To:      oldEmail,
+		Subject: "Your Chirpy email address is changing",
+		Body: fmt.Sprintf("Someone asked to change the email address of your Chirpy account to %s.\n\n"+
+			"If this wasn't you, reset your password and sign out your other sessions.\n", newEmail),
+	}
+}
+
+// prepareEmailChange records email as the user's pending address. The
+// login email only changes once the new address has been verified.
+func (cfg *apiConfig) prepareEmailChange(ctx context.Context, q *database.Queries, userID uuid.UUID, email string) (database.User, mailer.Message, error) {
+	_, err := q.GetUserByEmail(ctx, email)
+	if err == nil {
+		return database.User{}, mailer.Message{}, errEmailTaken
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, mailer.Message{}, err
+	}
+
+	user, err := q.SetUserPendingEmail(ctx, database.SetUserPendingEmailParams{
+		ID:           userID,
+		PendingEmail: sql.NullString{String: email, Valid: true},
+	})
+	if err != nil {
+		return database.User{}, mailer.Message{}, err
+	}
+
+	msg, err := cfg.prepareEmailVerification(ctx, q, userID, email)
+	if err != nil {
+		return database.User{}, mailer.Message{}, err
+	}
+	return user, msg, nil
+}
+
+func (cfg *apiConfig) handlerVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting transaction", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	verification, err := qtx.ConsumeEmailVerificationToken(r.Context(), auth.HashToken(params.Token))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired verification token", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check verification token", err)
+		return
+	}
+
+	user, err := qtx.VerifyUserEmail(r.Context(), database.VerifyUserEmailParams{
+		ID:    verification.UserID,
+		Email: verification.Email,
+	})
+	if isUniqueViolation(err) {
+		respondWithError(w, http.StatusConflict, "Email already in use", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, userFromDatabase(user))
+}
+
+func (cfg *apiConfig) handlerResendVerification(w http.ResponseWriter, r *http.Request) {
+	userID := principalFromRequest(r).UserID
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	email := user.PendingEmail.String
+	if !user.PendingEmail.Valid {
+		if user.EmailVerifiedAt.Valid {
+			respondWithError(w, http.StatusConflict, "Email already verified", nil)
+			return
+		}
+		email = user.Email
+	}
+
+	msg, err := cfg.prepareEmailVerification(r.Context(), cfg.dbQueries, user.ID, email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create verification token", err)
+		return
+	}
+	cfg.sendMail(msg)
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/circuit-shell/http-server-go/internal/auth"
 	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/google/uuid"
 )
 
 var EXPIRES_IN_SECONDS = 3600
@@ -18,12 +25,18 @@ type AuthenticatedUser struct {
 }
 
 type tokenResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
 }
 
 func (cfg *apiConfig) handleLogin(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		userInput
+		DeviceName string `json:"device_name"`
+	}
+
 	decoder := json.NewDecoder(r.Body)
-	userParams := userInput{}
+	userParams := parameters{}
 
 	// Decode the user input
 	err := decoder.Decode(&userParams)
@@ -32,56 +45,275 @@ func (cfg *apiConfig) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// refuse early while the account or the caller's IP is backing off
+	accountKey, ipKey := accountLoginKey(userParams.Email), ipLoginKey(r)
+	wait, err := cfg.loginRetryAfter(r.Context(), accountKey, ipKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check login attempts", err)
+		return
+	}
+	if wait > 0 {
+		respondTooManyAttempts(w, wait)
+		return
+	}
+
 	// Get the user from the database
 	user, err := cfg.dbQueries.GetUserByEmail(r.Context(), userParams.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		// spend the same time as a real password check
+		cfg.passwords.CheckDummy(userParams.Password)
+		cfg.rejectLogin(w, r, accountKey, ipKey, err)
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid user", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
 		return
 	}
 
-	// Check the password
-	if !auth.CheckPasswordHash(userParams.Password, user.HashedPassword) {
-		respondWithError(w, http.StatusUnauthorized, "Invalid password", err)
+	// Check the password; accounts created through an OIDC provider have
+	// none and always fail here
+	ok, needsRehash := cfg.passwords.Check(userParams.Password, user.HashedPassword)
+	if !ok {
+		cfg.rejectLogin(w, r, accountKey, ipKey, errors.New("wrong password"))
 		return
 	}
 
-	// Generate the access token
-	token, err := auth.MakeJWT(user.ID, cfg.serverSecret, time.Duration(EXPIRES_IN_SECONDS)*time.Second)
+	// upgrade a legacy or weaker hash now that we know the password; a
+	// failure here must not fail the login
+	if needsRehash {
+		cfg.rehashPassword(r.Context(), user, userParams.Password)
+	}
+
+	// with two-factor authentication on, the password only earns a
+	// short-lived challenge token for POST /api/login/mfa
+	enabled, err := mfaEnabled(r.Context(), cfg.dbQueries, user.ID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error generating token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor authentication", err)
+		return
+	}
+	if enabled {
+		cfg.respondWithMFAChallenge(w, user.ID)
 		return
 	}
 
-	// generate the resfresh token
-	refreshToken, err := auth.MakeRefreshToken()
+	// a completed login ends the account's backoff; the IP's is left to
+	// expire so one known account can't be used to reset it. With 2FA on,
+	// only the second step completes it, or the password alone would reset
+	// the backoff on code guesses.
+	err = cfg.dbQueries.ClearLoginAttempts(r.Context(), accountKey)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error generating refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record login attempt", err)
 		return
 	}
 
-	// Save the refresh token in the database
-	_, err = cfg.dbQueries.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
-		UserID: user.ID,
-		Token:  refreshToken,
-	})
+	authenticated, err := cfg.issueTokens(r.Context(), cfg.dbQueries, user, newSessionInfo(r, userParams.DeviceName))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error generating tokens", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, authenticated)
+
+}
 
+// rehashPassword replaces user's stored hash with one made by the current
+// hasher, unless the password was changed concurrently.
+func (cfg *apiConfig) rehashPassword(ctx context.Context, user database.User, password string) {
+	hashedPw, err := cfg.passwords.Hash(password)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error saving refresh token", err)
+		log.Printf("Error rehashing password of user %s: %s", user.ID, err)
 		return
 	}
+	err = cfg.dbQueries.RehashUserPassword(ctx, database.RehashUserPasswordParams{
+		ID:                user.ID,
+		HashedPassword:    hashedPw,
+		OldHashedPassword: user.HashedPassword,
+	})
+	if err != nil {
+		log.Printf("Error rehashing password of user %s: %s", user.ID, err)
+	}
+}
+
+// issueTokens creates the access token and a refresh token for user.
+// Every login starts a new token family, i.e. a new session, so other
+// devices stay logged in.
+func (cfg *apiConfig) issueTokens(ctx context.Context, q *database.Queries, user database.User, session sessionInfo) (AuthenticatedUser, error) {
+	principal := principalForUser(user).WithSession(uuid.New())
+	token, refreshToken, err := cfg.startSession(ctx, q, principal, session)
+	if err != nil {
+		return AuthenticatedUser{}, err
+	}
 
-	respondWithJSON(w, http.StatusOK, AuthenticatedUser{
-		User: User{
-			ID:          user.ID,
-			CreatedAt:   user.CreatedAt,
-			UpdatedAt:   user.CreatedAt,
-			Email:       user.Email,
-			IsChirpyRed: user.IsChirpyRed,
-		},
+	return AuthenticatedUser{
+		User:         userFromDatabase(user),
 		Token:        token,
 		RefreshToken: refreshToken,
+	}, nil
+}
+
+// startSession issues the first access and refresh token of the session
+// principal.SessionID. Tokens of third-party clients remember the client
+// and the scopes it was granted.
+func (cfg *apiConfig) startSession(ctx context.Context, q *database.Queries, principal auth.Principal, session sessionInfo) (string, string, error) {
+	token, err := cfg.keyring.MakeJWT(principal, time.Duration(EXPIRES_IN_SECONDS)*time.Second)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	params := database.CreateRefreshTokenParams{
+		UserID:           principal.UserID,
+		TokenHash:        auth.HashToken(refreshToken),
+		FamilyID:         principal.SessionID,
+		UserAgent:        session.UserAgent,
+		IpAddress:        session.IPAddress,
+		DeviceName:       session.DeviceName,
+		SessionStartedAt: time.Now().UTC(),
+	}
+	if principal.ClientID != "" {
+		params.ClientID = sql.NullString{String: principal.ClientID, Valid: true}
+		params.Scope = sql.NullString{String: strings.Join(principal.Scopes, " "), Valid: true}
+	}
+	_, err = q.CreateRefreshToken(ctx, params)
+	if err != nil {
+		return "", "", err
+	}
+	return token, refreshToken, nil
+}
+
+// errInvalidRefreshToken covers every refresh token a client must not be
+// told more about: unknown, expired, revoked, replayed or someone else's.
+var errInvalidRefreshToken = errors.New("invalid refresh token")
+
+// refreshedTokens is the outcome of a refresh token rotation.
+type refreshedTokens struct {
+	Token        string
+	RefreshToken string
+	Principal    auth.Principal
+}
+
+// rotateRefreshToken exchanges inputToken, issued to clientID or to the
+// first-party app when clientID is empty, for a new access token and a
+// new refresh token in the same family. A replayed token revokes its
+// whole family.
+func (cfg *apiConfig) rotateRefreshToken(r *http.Request, inputToken, clientID string) (refreshedTokens, error) {
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		return refreshedTokens{}, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	// look up the refresh token in the database, including revoked ones so
+	// that a replayed token can be detected
+	inputTokenHash := auth.HashToken(inputToken)
+	storedToken, err := qtx.GetRefreshToken(r.Context(), inputTokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return refreshedTokens{}, errInvalidRefreshToken
+	}
+	if err != nil {
+		return refreshedTokens{}, err
+	}
+	if storedToken.ClientID.String != clientID {
+		return refreshedTokens{}, fmt.Errorf("%w: issued to another client", errInvalidRefreshToken)
+	}
+
+	err = auth.CheckRefreshToken(auth.RefreshTokenState{
+		ExpiresAt: storedToken.ExpiresAt,
+		Revoked:   storedToken.RevokedAt.Valid,
+		Rotated:   storedToken.ReplacedByHash.Valid,
+	}, time.Now().UTC())
+	if errors.Is(err, auth.ErrRefreshTokenReused) {
+		return refreshedTokens{}, revokeTokenFamily(r.Context(), tx, qtx, storedToken.FamilyID)
+	}
+	if err != nil {
+		return refreshedTokens{}, fmt.Errorf("%w: %w", errInvalidRefreshToken, err)
+	}
+
+	// rotate: the presented token is revoked and replaced by a new one in the same family
+	newRefreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return refreshedTokens{}, err
+	}
+
+	newRefreshTokenHash := auth.HashToken(newRefreshToken)
+	rotated, err := qtx.RotateRefreshToken(r.Context(), database.RotateRefreshTokenParams{
+		TokenHash:      inputTokenHash,
+		ReplacedByHash: sql.NullString{String: newRefreshTokenHash, Valid: true},
 	})
+	if err != nil {
+		return refreshedTokens{}, err
+	}
+	if rotated == 0 {
+		// a concurrent request rotated this token first
+		return refreshedTokens{}, revokeTokenFamily(r.Context(), tx, qtx, storedToken.FamilyID)
+	}
+
+	// the session keeps its name, start, client and scope, but records
+	// where it was used last
+	session := newSessionInfo(r, "")
+	_, err = qtx.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+		UserID:           storedToken.UserID,
+		TokenHash:        newRefreshTokenHash,
+		FamilyID:         storedToken.FamilyID,
+		UserAgent:        session.UserAgent,
+		IpAddress:        session.IPAddress,
+		DeviceName:       storedToken.DeviceName,
+		SessionStartedAt: storedToken.SessionStartedAt,
+		ClientID:         storedToken.ClientID,
+		Scope:            storedToken.Scope,
+	})
+	if err != nil {
+		return refreshedTokens{}, err
+	}
+
+	// the role may have changed since login, so read it again
+	user, err := qtx.GetUserByID(r.Context(), storedToken.UserID)
+	if err != nil {
+		return refreshedTokens{}, fmt.Errorf("%w: %w", errInvalidRefreshToken, err)
+	}
+
+	principal := principalForUser(user)
+	if storedToken.ClientID.Valid {
+		// never more than was granted, nor more than the role still allows
+		granted := auth.ParseScope(storedToken.Scope.String)
+		scopes, _ := auth.GrantScopes(granted, granted, principal.Role)
+		principal = auth.NewOAuthPrincipal(user.ID, principal.Role, scopes, clientID)
+	}
+	principal = principal.WithSession(storedToken.FamilyID)
+
+	// Generate the refreshed access token
+	token, err := cfg.keyring.MakeJWT(principal, time.Duration(EXPIRES_IN_SECONDS)*time.Second)
+	if err != nil {
+		return refreshedTokens{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return refreshedTokens{}, err
+	}
 
+	return refreshedTokens{
+		Token:        token,
+		RefreshToken: newRefreshToken,
+		Principal:    principal,
+	}, nil
+}
+
+// revokeTokenFamily handles a replayed refresh token: every token in its
+// family is revoked and the caller is told the token is invalid.
+func revokeTokenFamily(ctx context.Context, tx *sql.Tx, qtx *database.Queries, familyID uuid.UUID) error {
+	err := qtx.RevokeRefreshTokenFamily(ctx, familyID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %w", errInvalidRefreshToken, auth.ErrRefreshTokenReused)
 }
 
 func (cfg *apiConfig) handleRefresh(w http.ResponseWriter, r *http.Request) {
@@ -92,23 +324,19 @@ func (cfg *apiConfig) handleRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// look up the refresh token in the database
-
-	refreshToken, err := cfg.dbQueries.GetUserFromRefreshToken(r.Context(), inputToken)
-	if err != nil {
+	refreshed, err := cfg.rotateRefreshToken(r, inputToken, "")
+	if errors.Is(err, errInvalidRefreshToken) {
 		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", err)
 		return
 	}
-
-	// Generate the refreshed access token
-	token, err := auth.MakeJWT(refreshToken.UserID, cfg.serverSecret, time.Duration(EXPIRES_IN_SECONDS)*time.Second)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error generating token", err)
+		respondWithError(w, http.StatusInternalServerError, "Error rotating refresh token", err)
 		return
 	}
 
 	respondWithJSON(w, http.StatusOK, tokenResponse{
-		Token: token,
+		Token:        refreshed.Token,
+		RefreshToken: refreshed.RefreshToken,
 	})
 }
 
@@ -122,14 +350,14 @@ func (cfg *apiConfig) handleRevoke(w http.ResponseWriter, r *http.Request) {
 
 	// look up the refresh token in the database
 
-	refreshToken, err := cfg.dbQueries.GetUserFromRefreshToken(r.Context(), inputToken)
+	refreshToken, err := cfg.dbQueries.GetUserFromRefreshToken(r.Context(), auth.HashToken(inputToken))
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", err)
 		return
 	}
 
-	// Revoke the refresh token
-	err = cfg.dbQueries.RevokeRefreshTokens(r.Context(), refreshToken.UserID)
+	// Revoke the refresh token and everything rotated from the same login
+	err = cfg.dbQueries.RevokeRefreshTokenFamily(r.Context(), refreshToken.FamilyID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error revoking refresh token", err)
 		return
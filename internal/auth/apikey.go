@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// APIKeyPrefix starts every API key, so leaked keys are easy to spot.
+const APIKeyPrefix = "chirpy_"
+
+// MakeAPIKey returns a new random API key. Only HashToken of it should be
+// stored; the key itself is shown to its owner once.
+func MakeAPIKey() (string, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+	return APIKeyPrefix + hex.EncodeToString(key), nil
+}
+
+// APIKeyHint is the start of key, enough for a user to tell their keys
+// apart without revealing them.
+func APIKeyHint(key string) string {
+	return key[:min(len(key), len(APIKeyPrefix)+6)]
+}
+
+// GetAPIKey reads the key of an "Authorization: ApiKey <key>" header.
+func GetAPIKey(headers http.Header) (string, error) {
+	authorization := headers.Get("Authorization")
+	if authorization == "" {
+		return "", errors.New("missing Authorization header")
+	}
+
+	key, found := strings.CutPrefix(authorization, "ApiKey ")
+	if !found || !strings.HasPrefix(key, APIKeyPrefix) {
+		return "", errors.New("invalid Authorization header")
+	}
+	return key, nil
+}
+
+// CheckScopes makes sure every one of scopes is one role may grant.
+func CheckScopes(role Role, scopes []string) error {
+	allowed := RoleScopes(role)
+	for _, scope := range scopes {
+		if !slices.Contains(allowed, scope) {
+			return fmt.Errorf("scope %q is not available to role %s", scope, role)
+		}
+	}
+	return nil
+}
+
+// NewAPIKeyPrincipal is the caller authenticated by an API key. The key
+// keeps only those of its scopes that the owner's current role still
+// grants, so demoting a user also weakens their keys.
+func NewAPIKeyPrincipal(userID uuid.UUID, role Role, keyScopes []string) Principal {
+	allowed := RoleScopes(role)
+	scopes := []string{}
+	for _, scope := range keyScopes {
+		if slices.Contains(allowed, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return Principal{
+		UserID:  userID,
+		Role:    role,
+		Scopes:  scopes,
+		FromKey: true,
+	}
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestGetAPIKey(t *testing.T) {
+	key, err := MakeAPIKey()
+	if err != nil {
+		t.Fatalf("MakeAPIKey() error = %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          string
+		wantErr       bool
+	}{
+		{
+			name:          "api key",
+			authorization: "ApiKey " + key,
+			want:          key,
+		},
+		{
+			name:    "missing header",
+			wantErr: true,
+		},
+		{
+			name:          "bearer token",
+			authorization: "Bearer " + key,
+			wantErr:       true,
+		},
+		{
+			name:          "not a chirpy key",
+			authorization: "ApiKey abc123",
+			wantErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := http.Header{}
+			if tt.authorization != "" {
+				headers.Set("Authorization", tt.authorization)
+			}
+			got, err := GetAPIKey(headers)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetAPIKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GetAPIKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMakeAPIKey(t *testing.T) {
+	key, err := MakeAPIKey()
+	if err != nil {
+		t.Fatalf("MakeAPIKey() error = %v", err)
+	}
+	if !strings.HasPrefix(key, APIKeyPrefix) || len(key) != len(APIKeyPrefix)+64 {
+		t.Errorf("MakeAPIKey() = %q, want %s plus 64 hex digits", key, APIKeyPrefix)
+	}
+	if hint := APIKeyHint(key); !strings.HasPrefix(key, hint) || len(hint) >= len(key) {
+		t.Errorf("APIKeyHint() = %q, want a short prefix of the key", hint)
+	}
+}
+
+func TestCheckScopes(t *testing.T) {
+	if err := CheckScopes(RoleUser, []string{ScopeChirpsWrite}); err != nil {
+		t.Errorf("CheckScopes(user, chirps:write) error = %v", err)
+	}
+	if err := CheckScopes(RoleUser, []string{ScopeChirpsWrite, ScopeAdmin}); err == nil {
+		t.Error("CheckScopes(user, admin) accepted a scope the role lacks")
+	}
+}
+
+func TestNewAPIKeyPrincipal(t *testing.T) {
+	// the key was created by an admin who has since been demoted
+	got := NewAPIKeyPrincipal(uuid.New(), RoleUser, []string{ScopeChirpsWrite, ScopeAdmin})
+	if !slices.Equal(got.Scopes, []string{ScopeChirpsWrite}) {
+		t.Errorf("NewAPIKeyPrincipal() scopes = %v, want [%s]", got.Scopes, ScopeChirpsWrite)
+	}
+	if !got.FromKey {
+		t.Error("NewAPIKeyPrincipal() FromKey = false")
+	}
+}
@@ -1,7 +1,6 @@
 package auth
 
 import (
-	"golang.org/x/crypto/bcrypt"
 	"strings"
 	"testing"
 )
@@ -34,10 +33,10 @@ func TestHashPassword(t *testing.T) {
 		},
 		
 		{
-			name:          "password exceeding max length",
-			password:      strings.Repeat("a", 73), // should still work but will be truncated
-			wantErr: true,
-			errorContains: "password length exceeds 72 bytes",
+			name:          "password exceeding bcrypt max length",
+			password:      strings.Repeat("a", 73), // argon2id has no 72 byte limit
+			wantErr:       false,
+			errorContains: "",
 		},
 	}
 
@@ -61,9 +60,13 @@ func TestHashPassword(t *testing.T) {
 					return
 				}
 
-				err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(tt.password))
-				if err != nil {
-					t.Errorf("Failed to verify hashed password: %v", err)
+				if !strings.HasPrefix(hashedPassword, "$argon2id$v=19$") {
+					t.Errorf("HashPassword() = %q, want an argon2id PHC string", hashedPassword)
+					return
+				}
+
+				if !CheckPasswordHash(tt.password, hashedPassword) {
+					t.Error("Failed to verify hashed password")
 					return
 				}
 
@@ -132,14 +135,6 @@ func TestCheckPasswordHash(t *testing.T) {
 				t.Errorf("CheckPasswordHash() = %v, want %v", got, tt.want)
 			}
 
-			// Additional verification for positive cases
-			if tt.want {
-				// Double-check with bcrypt directly
-				err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(tt.password))
-				if err != nil {
-					t.Errorf("bcrypt.CompareHashAndPassword() failed but CheckPasswordHash() passed: %v", err)
-				}
-			}
 		})
 	}
 }
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+const (
+	ScopeChirpsWrite    = "chirps:write"
+	ScopeChirpsModerate = "chirps:moderate"
+	ScopeUsersWrite     = "users:write"
+	ScopeAdmin          = "admin"
+)
+
+// RoleScopes lists the scopes a first-party access token carries for role.
+func RoleScopes(role Role) []string {
+	scopes := []string{ScopeChirpsWrite, ScopeUsersWrite}
+	switch role {
+	case RoleModerator:
+		scopes = append(scopes, ScopeChirpsModerate)
+	case RoleAdmin:
+		scopes = append(scopes, ScopeChirpsModerate, ScopeAdmin)
+	}
+	return scopes
+}
+
+// Claims are the JWT claims of a Chirpy access token. Scope is a space
+// separated list, as in OAuth 2.0. SessionID is the login session, i.e.
+// the refresh token family, the token was issued for. ClientID names the
+// OAuth client of third-party tokens, as in RFC 9068.
+type Claims struct {
+	jwt.RegisteredClaims
+	Role      Role   `json:"role,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+}
+
+// Principal is the authenticated caller of a request. SessionID is
+// uuid.Nil when the token isn't tied to a session. FromKey is set when
+// the caller used an API key rather than an access token, and ClientID
+// when it acts through a third-party OAuth client.
+type Principal struct {
+	UserID    uuid.UUID
+	Role      Role
+	Scopes    []string
+	SessionID uuid.UUID
+	FromKey   bool
+	ClientID  string
+}
+
+func NewPrincipal(userID uuid.UUID, role Role) Principal {
+	return Principal{
+		UserID: userID,
+		Role:   role,
+		Scopes: RoleScopes(role),
+	}
+}
+
+func (p Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// WithSession returns a copy of p tied to the login session sessionID.
+func (p Principal) WithSession(sessionID uuid.UUID) Principal {
+	p.SessionID = sessionID
+	return p
+}
+
+func (p Principal) claims() Claims {
+	claims := Claims{
+		Role:     p.Role,
+		Scope:    strings.Join(p.Scopes, " "),
+		ClientID: p.ClientID,
+	}
+	if p.SessionID != uuid.Nil {
+		claims.SessionID = p.SessionID.String()
+	}
+	return claims
+}
+
+func principalFromClaims(userID uuid.UUID, claims *Claims) Principal {
+	// tokens minted before roles existed carry neither claim
+	principal := NewPrincipal(userID, RoleUser)
+	if claims.Role != "" {
+		principal = Principal{
+			UserID:   userID,
+			Role:     claims.Role,
+			Scopes:   strings.Fields(claims.Scope),
+			ClientID: claims.ClientID,
+		}
+	}
+	// a malformed sid leaves the token usable, just not tied to a session
+	if sessionID, err := uuid.Parse(claims.SessionID); err == nil {
+		principal.SessionID = sessionID
+	}
+	return principal
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
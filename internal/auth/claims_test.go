@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func TestRoleScopes(t *testing.T) {
+	tests := []struct {
+		role      Role
+		wantScope string
+		want      bool
+	}{
+		{role: RoleUser, wantScope: ScopeChirpsWrite, want: true},
+		{role: RoleUser, wantScope: ScopeChirpsModerate, want: false},
+		{role: RoleUser, wantScope: ScopeAdmin, want: false},
+		{role: RoleModerator, wantScope: ScopeChirpsModerate, want: true},
+		{role: RoleModerator, wantScope: ScopeAdmin, want: false},
+		{role: RoleAdmin, wantScope: ScopeAdmin, want: true},
+		{role: RoleAdmin, wantScope: ScopeChirpsModerate, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.role)+" "+tt.wantScope, func(t *testing.T) {
+			got := NewPrincipal(uuid.New(), tt.role).HasScope(tt.wantScope)
+			if got != tt.want {
+				t.Errorf("HasScope(%q) = %v, want %v", tt.wantScope, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrincipalClaimsRoundTrip(t *testing.T) {
+	keyring, err := NewKeyring(NewHMACKey("", "secret"))
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+
+	principal := NewPrincipal(uuid.New(), RoleModerator).WithSession(uuid.New())
+	token, err := keyring.MakeJWT(principal, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT() error = %v", err)
+	}
+	got, err := keyring.ValidateJWT(token)
+	if err != nil {
+		t.Fatalf("ValidateJWT() error = %v", err)
+	}
+	if got.Role != RoleModerator || !slices.Equal(got.Scopes, principal.Scopes) || got.SessionID != principal.SessionID {
+		t.Errorf("ValidateJWT() = %+v, want %+v", got, principal)
+	}
+}
+
+func TestPrincipalFromLegacyToken(t *testing.T) {
+	// tokens issued before roles existed only carry registered claims
+	userID := uuid.New()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:    string(TokenTypeAccess),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		Subject:   userID.String(),
+	}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatalf("SignedString() error = %v", err)
+	}
+
+	keyring, err := NewKeyring(NewHMACKey("", "secret"))
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	got, err := keyring.ValidateJWT(token)
+	if err != nil {
+		t.Fatalf("ValidateJWT() error = %v", err)
+	}
+	if got.UserID != userID || got.Role != RoleUser || !got.HasScope(ScopeChirpsWrite) || got.SessionID != uuid.Nil {
+		t.Errorf("ValidateJWT() = %+v, want plain user principal", got)
+	}
+}
+
+func TestPrincipalContext(t *testing.T) {
+	if _, ok := PrincipalFromContext(context.Background()); ok {
+		t.Error("PrincipalFromContext() found a principal in an empty context")
+	}
+
+	principal := NewPrincipal(uuid.New(), RoleAdmin)
+	got, ok := PrincipalFromContext(WithPrincipal(context.Background(), principal))
+	if !ok || got.UserID != principal.UserID {
+		t.Errorf("PrincipalFromContext() = %+v, %v; want %+v", got, ok, principal)
+	}
+}
@@ -0,0 +1,318 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// SigningKey is a JWT key identified by its kid. A key loaded from a
+// public key only can verify tokens but never sign them, which is how
+// retired keys are kept around until their tokens have expired.
+type SigningKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	signKey   any
+	verifyKey any
+}
+
+func NewHMACKey(id, secret string) *SigningKey {
+	return &SigningKey{
+		ID:        id,
+		Method:    jwt.SigningMethodHS256,
+		signKey:   []byte(secret),
+		verifyKey: []byte(secret),
+	}
+}
+
+func NewRSAKey(id string, key *rsa.PrivateKey) *SigningKey {
+	return &SigningKey{
+		ID:        id,
+		Method:    jwt.SigningMethodRS256,
+		signKey:   key,
+		verifyKey: &key.PublicKey,
+	}
+}
+
+func NewEd25519Key(id string, key ed25519.PrivateKey) *SigningKey {
+	return &SigningKey{
+		ID:        id,
+		Method:    jwt.SigningMethodEdDSA,
+		signKey:   key,
+		verifyKey: key.Public(),
+	}
+}
+
+// ParseKeyPEM reads an RSA or Ed25519 key from PEM. Private keys may be
+// PKCS#8 or PKCS#1; a PKIX public key yields a verify-only key.
+func ParseKeyPEM(id string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %q: no PEM block found", id)
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		return NewRSAKey(id, key), nil
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			return NewRSAKey(id, key), nil
+		case ed25519.PrivateKey:
+			return NewEd25519Key(id, key), nil
+		}
+		return nil, fmt.Errorf("key %q: unsupported private key type %T", id, key)
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		switch key := key.(type) {
+		case *rsa.PublicKey:
+			return &SigningKey{ID: id, Method: jwt.SigningMethodRS256, verifyKey: key}, nil
+		case ed25519.PublicKey:
+			return &SigningKey{ID: id, Method: jwt.SigningMethodEdDSA, verifyKey: key}, nil
+		}
+		return nil, fmt.Errorf("key %q: unsupported public key type %T", id, key)
+	}
+	return nil, fmt.Errorf("key %q: unsupported PEM block %q", id, block.Type)
+}
+
+// Keyring signs access tokens with its active key and verifies tokens
+// signed by any key it holds, so rotating the active key doesn't
+// invalidate tokens that are still in flight.
+type Keyring struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+// NewKeyring builds a keyring from the active key plus any retired keys.
+// Tokens without a kid header are checked against the key with an empty
+// ID, which lets a pre-rotation HMAC secret keep verifying old tokens.
+func NewKeyring(active *SigningKey, retired ...*SigningKey) (*Keyring, error) {
+	if active == nil || active.signKey == nil {
+		return nil, errors.New("active key must be able to sign")
+	}
+
+	k := &Keyring{
+		active: active,
+		keys:   map[string]*SigningKey{active.ID: active},
+	}
+	for _, key := range retired {
+		if _, exists := k.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate key ID %q", key.ID)
+		}
+		k.keys[key.ID] = key
+	}
+	return k, nil
+}
+
+// LoadKeyringDir loads every *.pem file in dir, using the file name
+// without extension as the kid, and makes activeID the signing key.
+func LoadKeyringDir(dir, activeID string) (*Keyring, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	var active *SigningKey
+	retired := []*SigningKey{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		id := strings.TrimSuffix(filepath.Base(path), ".pem")
+		key, err := ParseKeyPEM(id, data)
+		if err != nil {
+			return nil, err
+		}
+		if id == activeID {
+			active = key
+			continue
+		}
+		retired = append(retired, key)
+	}
+
+	if active == nil {
+		return nil, fmt.Errorf("active key %q not found in %s", activeID, dir)
+	}
+	return NewKeyring(active, retired...)
+}
+
+// Add registers another verify key, e.g. a legacy HMAC secret.
+func (k *Keyring) Add(key *SigningKey) error {
+	if _, exists := k.keys[key.ID]; exists {
+		return fmt.Errorf("duplicate key ID %q", key.ID)
+	}
+	k.keys[key.ID] = key
+	return nil
+}
+
+func (k *Keyring) MakeJWT(principal Principal, expiresIn time.Duration) (string, error) {
+	claims := principal.claims()
+	claims.RegisteredClaims = registeredClaims(TokenTypeAccess, principal.UserID, expiresIn)
+	return k.sign(claims)
+}
+
+func (k *Keyring) ValidateJWT(tokenString string) (Principal, error) {
+	principal, _, err := k.IntrospectJWT(tokenString)
+	return principal, err
+}
+
+// IntrospectJWT validates an access token like ValidateJWT and also
+// returns its registered claims, for token introspection.
+func (k *Keyring) IntrospectJWT(tokenString string) (Principal, jwt.RegisteredClaims, error) {
+	claimsStruct := Claims{}
+	id, err := k.parse(tokenString, TokenTypeAccess, &claimsStruct)
+	if err != nil {
+		return Principal{}, jwt.RegisteredClaims{}, err
+	}
+	return principalFromClaims(id, &claimsStruct), claimsStruct.RegisteredClaims, nil
+}
+
+// MakeMFAToken issues the short-lived token proving that the password
+// step of a login succeeded. It is not accepted as an access token.
+func (k *Keyring) MakeMFAToken(userID uuid.UUID, expiresIn time.Duration) (string, error) {
+	return k.sign(registeredClaims(TokenTypeMFA, userID, expiresIn))
+}
+
+func (k *Keyring) ValidateMFAToken(tokenString string) (uuid.UUID, error) {
+	return k.parse(tokenString, TokenTypeMFA, &jwt.RegisteredClaims{})
+}
+
+func registeredClaims(tokenType TokenType, userID uuid.UUID, expiresIn time.Duration) jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Issuer:    string(tokenType),
+		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+		Subject:   userID.String(),
+	}
+}
+
+func (k *Keyring) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.active.Method, claims)
+	if k.active.ID != "" {
+		token.Header["kid"] = k.active.ID
+	}
+	return token.SignedString(k.active.signKey)
+}
+
+// parse verifies tokenString, checks that it was issued as tokenType and
+// returns its subject.
+func (k *Keyring) parse(tokenString string, tokenType TokenType, claims jwt.Claims) (uuid.UUID, error) {
+	token, err := jwt.ParseWithClaims(tokenString, claims, k.keyFunc)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	userIDString, err := token.Claims.GetSubject()
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	issuer, err := token.Claims.GetIssuer()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if issuer != string(tokenType) {
+		return uuid.Nil, errors.New("invalid issuer")
+	}
+
+	id, err := uuid.Parse(userIDString)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
+	}
+	return id, nil
+}
+
+func (k *Keyring) keyFunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	// the algorithm is pinned by the key, never chosen by the token
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), kid)
+	}
+	return key.verifyKey, nil
+}
+
+// JWK is the public half of an asymmetric key as published in a JWKS.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS lists the public keys of every asymmetric key in the keyring,
+// active key first. HMAC secrets are never published.
+func (k *Keyring) JWKS() JWKS {
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if (ids[i] == k.active.ID) != (ids[j] == k.active.ID) {
+			return ids[i] == k.active.ID
+		}
+		return ids[i] < ids[j]
+	})
+
+	set := JWKS{Keys: []JWK{}}
+	for _, id := range ids {
+		key := k.keys[id]
+		switch pub := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	return set
+}
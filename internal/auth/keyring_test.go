@@ -0,0 +1,274 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func newTestRSAKey(t *testing.T, id string) *SigningKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	return NewRSAKey(id, key)
+}
+
+func newTestEd25519Key(t *testing.T, id string) *SigningKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+	return NewEd25519Key(id, key)
+}
+
+func TestKeyringRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     *SigningKey
+		wantAlg string
+	}{
+		{
+			name:    "RS256",
+			key:     newTestRSAKey(t, "rsa-1"),
+			wantAlg: "RS256",
+		},
+		{
+			name:    "EdDSA",
+			key:     newTestEd25519Key(t, "ed-1"),
+			wantAlg: "EdDSA",
+		},
+		{
+			name:    "HS256",
+			key:     NewHMACKey("hs-1", "secret"),
+			wantAlg: "HS256",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring, err := NewKeyring(tt.key)
+			if err != nil {
+				t.Fatalf("NewKeyring() error = %v", err)
+			}
+
+			principal := NewPrincipal(uuid.New(), RoleUser)
+			tokenString, err := keyring.MakeJWT(principal, time.Hour)
+			if err != nil {
+				t.Fatalf("MakeJWT() error = %v", err)
+			}
+
+			token, _, err := jwt.NewParser().ParseUnverified(tokenString, &jwt.RegisteredClaims{})
+			if err != nil {
+				t.Fatalf("ParseUnverified() error = %v", err)
+			}
+			if token.Header["kid"] != tt.key.ID {
+				t.Errorf("kid header = %v, want %v", token.Header["kid"], tt.key.ID)
+			}
+			if token.Header["alg"] != tt.wantAlg {
+				t.Errorf("alg header = %v, want %v", token.Header["alg"], tt.wantAlg)
+			}
+
+			got, err := keyring.ValidateJWT(tokenString)
+			if err != nil {
+				t.Fatalf("ValidateJWT() error = %v", err)
+			}
+			if got.UserID != principal.UserID {
+				t.Errorf("ValidateJWT() user = %v, want %v", got.UserID, principal.UserID)
+			}
+		})
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	oldKey := newTestRSAKey(t, "2024-01")
+	newKey := newTestEd25519Key(t, "2024-02")
+
+	oldKeyring, err := NewKeyring(oldKey)
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	userID := uuid.New()
+	inFlight, err := oldKeyring.MakeJWT(NewPrincipal(userID, RoleUser), time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT() error = %v", err)
+	}
+
+	// rotate: the old key is retired and only verifies
+	rotated, err := NewKeyring(newKey, oldKey)
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	got, err := rotated.ValidateJWT(inFlight)
+	if err != nil {
+		t.Fatalf("ValidateJWT() of in-flight token error = %v", err)
+	}
+	if got.UserID != userID {
+		t.Errorf("ValidateJWT() user = %v, want %v", got.UserID, userID)
+	}
+
+	// once the old key is dropped its tokens are rejected
+	withoutOld, err := NewKeyring(newKey)
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	_, err = withoutOld.ValidateJWT(inFlight)
+	if err == nil || !strings.Contains(err.Error(), "unknown key ID") {
+		t.Errorf("ValidateJWT() error = %v, want unknown key ID", err)
+	}
+}
+
+func TestKeyringRejectsAlgorithmMismatch(t *testing.T) {
+	rsaKey := newTestRSAKey(t, "rsa-1")
+	keyring, err := NewKeyring(rsaKey)
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+
+	// an HS256 token claiming the RSA kid, signed with the public key bytes
+	pubDER, err := x509.MarshalPKIXPublicKey(rsaKey.verifyKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey() error = %v", err)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:    string(TokenTypeAccess),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		Subject:   uuid.New().String(),
+	})
+	token.Header["kid"] = "rsa-1"
+	forged, err := token.SignedString(pubDER)
+	if err != nil {
+		t.Fatalf("SignedString() error = %v", err)
+	}
+
+	if _, err := keyring.ValidateJWT(forged); err == nil {
+		t.Error("ValidateJWT() accepted a token with a mismatched algorithm")
+	}
+}
+
+func TestKeyringJWKS(t *testing.T) {
+	active := newTestEd25519Key(t, "b-active")
+	retired := newTestRSAKey(t, "a-retired")
+	keyring, err := NewKeyring(active, retired, NewHMACKey("", "secret"))
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+
+	set := keyring.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("JWKS() returned %d keys, want 2 (HMAC must not be published)", len(set.Keys))
+	}
+	if set.Keys[0].Kid != "b-active" || set.Keys[0].Kty != "OKP" || set.Keys[0].Crv != "Ed25519" || set.Keys[0].X == "" {
+		t.Errorf("JWKS() first key = %+v, want active Ed25519 key", set.Keys[0])
+	}
+	if set.Keys[1].Kid != "a-retired" || set.Keys[1].Kty != "RSA" || set.Keys[1].E != "AQAB" || set.Keys[1].N == "" {
+		t.Errorf("JWKS() second key = %+v, want retired RSA key", set.Keys[1])
+	}
+}
+
+func TestParseKeyPEM(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(edPriv)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey() error = %v", err)
+	}
+	pkix, err := x509.MarshalPKIXPublicKey(edPub)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		block       *pem.Block
+		wantAlg     string
+		wantCanSign bool
+		wantErr     bool
+	}{
+		{
+			name:        "PKCS1 RSA private key",
+			block:       &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)},
+			wantAlg:     "RS256",
+			wantCanSign: true,
+		},
+		{
+			name:        "PKCS8 Ed25519 private key",
+			block:       &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8},
+			wantAlg:     "EdDSA",
+			wantCanSign: true,
+		},
+		{
+			name:        "PKIX Ed25519 public key",
+			block:       &pem.Block{Type: "PUBLIC KEY", Bytes: pkix},
+			wantAlg:     "EdDSA",
+			wantCanSign: false,
+		},
+		{
+			name:    "unsupported block",
+			block:   &pem.Block{Type: "CERTIFICATE", Bytes: []byte("x")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseKeyPEM("kid", pem.EncodeToMemory(tt.block))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKeyPEM() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if key.Method.Alg() != tt.wantAlg {
+				t.Errorf("ParseKeyPEM() alg = %s, want %s", key.Method.Alg(), tt.wantAlg)
+			}
+			if (key.signKey != nil) != tt.wantCanSign {
+				t.Errorf("ParseKeyPEM() can sign = %v, want %v", key.signKey != nil, tt.wantCanSign)
+			}
+		})
+	}
+}
+
+func TestMFATokenIsNotAnAccessToken(t *testing.T) {
+	keyring, err := NewKeyring(newTestEd25519Key(t, "ed-1"))
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+
+	userID := uuid.New()
+	mfaToken, err := keyring.MakeMFAToken(userID, time.Minute)
+	if err != nil {
+		t.Fatalf("MakeMFAToken() error = %v", err)
+	}
+	gotID, err := keyring.ValidateMFAToken(mfaToken)
+	if err != nil || gotID != userID {
+		t.Errorf("ValidateMFAToken() = %v, %v; want %v", gotID, err, userID)
+	}
+	if _, err := keyring.ValidateJWT(mfaToken); err == nil {
+		t.Error("ValidateJWT() accepted an MFA challenge token")
+	}
+
+	accessToken, err := keyring.MakeJWT(NewPrincipal(userID, RoleUser), time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT() error = %v", err)
+	}
+	if _, err := keyring.ValidateMFAToken(accessToken); err == nil {
+		t.Error("ValidateMFAToken() accepted an access token")
+	}
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	// the example of RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	tests := []struct {
+		name      string
+		verifier  string
+		challenge string
+		want      bool
+	}{
+		{
+			name:      "matching verifier",
+			verifier:  verifier,
+			challenge: challenge,
+			want:      true,
+		},
+		{
+			name:      "wrong verifier",
+			verifier:  strings.Replace(verifier, "d", "e", 1),
+			challenge: challenge,
+			want:      false,
+		},
+		{
+			name:      "plain method",
+			verifier:  verifier,
+			challenge: verifier,
+			want:      false,
+		},
+		{
+			name:      "verifier too short",
+			verifier:  "abc",
+			challenge: PKCEChallenge("abc"),
+			want:      false,
+		},
+		{
+			name:      "verifier with reserved characters",
+			verifier:  verifier + "/+",
+			challenge: PKCEChallenge(verifier + "/+"),
+			want:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyPKCE(tt.verifier, tt.challenge); got != tt.want {
+				t.Errorf("VerifyPKCE() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGrantScopes(t *testing.T) {
+	clientScopes := []string{ScopeChirpsWrite, ScopeChirpsModerate}
+
+	tests := []struct {
+		name      string
+		requested []string
+		role      Role
+		want      []string
+		wantErr   bool
+	}{
+		{
+			name:      "subset of client scopes",
+			requested: []string{ScopeChirpsWrite},
+			role:      RoleModerator,
+			want:      []string{ScopeChirpsWrite},
+		},
+		{
+			name: "empty request means every client scope",
+			role: RoleModerator,
+			want: []string{ScopeChirpsWrite, ScopeChirpsModerate},
+		},
+		{
+			name:      "role lacks a requested scope",
+			requested: []string{ScopeChirpsWrite, ScopeChirpsModerate},
+			role:      RoleUser,
+			want:      []string{ScopeChirpsWrite},
+		},
+		{
+			name:      "scope not registered for the client",
+			requested: []string{ScopeUsersWrite},
+			role:      RoleAdmin,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GrantScopes(tt.requested, clientScopes, tt.role)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GrantScopes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Errorf("GrantScopes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOAuthPrincipalRoundTrip(t *testing.T) {
+	keyring, err := NewKeyring(NewHMACKey("", "secret"))
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+
+	principal := NewOAuthPrincipal(uuid.New(), RoleAdmin, []string{ScopeChirpsWrite}, "client-1").WithSession(uuid.New())
+	token, err := keyring.MakeJWT(principal, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT() error = %v", err)
+	}
+	got, err := keyring.ValidateJWT(token)
+	if err != nil {
+		t.Fatalf("ValidateJWT() error = %v", err)
+	}
+	if got.ClientID != "client-1" || !slices.Equal(got.Scopes, []string{ScopeChirpsWrite}) || got.HasScope(ScopeAdmin) {
+		t.Errorf("ValidateJWT() = %+v, want the client's narrowed scopes", got)
+	}
+
+	_, claims, err := keyring.IntrospectJWT(token)
+	if err != nil {
+		t.Fatalf("IntrospectJWT() error = %v", err)
+	}
+	if claims.ExpiresAt == nil || claims.IssuedAt == nil || claims.Subject != principal.UserID.String() {
+		t.Errorf("IntrospectJWT() claims = %+v, want exp, iat and sub", claims)
+	}
+}
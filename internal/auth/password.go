@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hasher is one password hashing algorithm with fixed parameters.
+type Hasher interface {
+	Hash(password string) (string, error)
+	// Identifies reports whether hash was made by this algorithm,
+	// whatever its parameters.
+	Identifies(hash string) bool
+	Verify(password, hash string) bool
+	// NeedsRehash reports whether hash, made by this algorithm, uses
+	// weaker parameters than the hasher's own.
+	NeedsRehash(hash string) bool
+}
+
+// Argon2idHasher hashes passwords with argon2id and stores them in PHC
+// string format: $argon2id$v=19$m=<KiB>,t=<iterations>,p=<lanes>$salt$key
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2id follows the first recommended option of RFC 9106 with
+// the memory scaled down for a web server: 64 MiB, 3 passes, 2 lanes.
+var DefaultArgon2id = Argon2idHasher{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+var errInvalidPHC = errors.New("invalid argon2id hash")
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h Argon2idHasher) Identifies(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+func (h Argon2idHasher) Verify(password, hash string) bool {
+	params, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return false
+	}
+	got := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(got, key) == 1
+}
+
+func (h Argon2idHasher) NeedsRehash(hash string) bool {
+	params, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return params.Memory < h.Memory ||
+		params.Iterations < h.Iterations ||
+		params.Parallelism < h.Parallelism ||
+		uint32(len(salt)) < h.SaltLength ||
+		uint32(len(key)) < h.KeyLength
+}
+
+func parseArgon2id(hash string) (Argon2idHasher, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Argon2idHasher{}, nil, nil, errInvalidPHC
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return Argon2idHasher{}, nil, nil, errInvalidPHC
+	}
+
+	params := Argon2idHasher{}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil || params.Iterations == 0 || params.Parallelism == 0 {
+		return Argon2idHasher{}, nil, nil, errInvalidPHC
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2idHasher{}, nil, nil, errInvalidPHC
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Argon2idHasher{}, nil, nil, errInvalidPHC
+	}
+	return params, salt, key, nil
+}
+
+// BcryptHasher is kept so hashes made before argon2id still verify.
+// bcrypt hashes are modular crypt strings, which PHC grew out of.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+func (h BcryptHasher) Identifies(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (h BcryptHasher) Verify(password, hash string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func (h BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < h.Cost
+}
+
+// PasswordPolicy hashes new passwords with Current and still verifies
+// hashes made by any of Legacy.
+type PasswordPolicy struct {
+	Current Hasher
+	Legacy  []Hasher
+
+	dummyOnce sync.Once
+	dummyHash string
+}
+
+func NewPasswordPolicy(current Hasher, legacy ...Hasher) *PasswordPolicy {
+	return &PasswordPolicy{Current: current, Legacy: legacy}
+}
+
+func (p *PasswordPolicy) Hash(password string) (string, error) {
+	return p.Current.Hash(password)
+}
+
+// Check verifies password against hash. needsRehash is true when the
+// password matched but the hash was made by a legacy algorithm or with
+// weaker parameters, so the caller should store a fresh Hash. An empty
+// hash, as accounts without a password have, never matches.
+func (p *PasswordPolicy) Check(password, hash string) (ok bool, needsRehash bool) {
+	if p.Current.Identifies(hash) {
+		ok = p.Current.Verify(password, hash)
+		return ok, ok && p.Current.NeedsRehash(hash)
+	}
+	for _, legacy := range p.Legacy {
+		if legacy.Identifies(hash) {
+			ok = legacy.Verify(password, hash)
+			return ok, ok
+		}
+	}
+	// take as long as a real check, so accounts without a password can't
+	// be told apart
+	return p.CheckDummy(password), false
+}
+
+// CheckDummy spends as long as Check does for a real account and always
+// fails. Login runs it for unknown emails, so response times don't tell
+// which accounts exist.
+func (p *PasswordPolicy) CheckDummy(password string) bool {
+	p.dummyOnce.Do(func() {
+		p.dummyHash, _ = p.Current.Hash("chirpy-dummy-password")
+	})
+	p.Current.Verify(password, p.dummyHash)
+	return false
+}
+
+var defaultPasswords = NewPasswordPolicy(DefaultArgon2id, BcryptHasher{Cost: 10})
+
+func HashPassword(password string) (string, error) {
+	return defaultPasswords.Hash(password)
+}
+
+func CheckPasswordHash(password, hash string) bool {
+	ok, _ := defaultPasswords.Check(password, hash)
+	return ok
+}
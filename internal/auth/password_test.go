@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheap parameters so the tests stay fast
+var testArgon2id = Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	policy := NewPasswordPolicy(testArgon2id, BcryptHasher{Cost: bcrypt.MinCost})
+
+	current, err := policy.Hash("hunter2")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	weaker, err := Argon2idHasher{Memory: 512, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}.Hash("hunter2")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	legacy, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword() error = %v", err)
+	}
+
+	tests := []struct {
+		name            string
+		password        string
+		hash            string
+		wantOK          bool
+		wantNeedsRehash bool
+	}{
+		{
+			name:     "current parameters",
+			password: "hunter2",
+			hash:     current,
+			wantOK:   true,
+		},
+		{
+			name:     "wrong password",
+			password: "hunter3",
+			hash:     current,
+		},
+		{
+			name:            "weaker argon2id parameters",
+			password:        "hunter2",
+			hash:            weaker,
+			wantOK:          true,
+			wantNeedsRehash: true,
+		},
+		{
+			name:            "legacy bcrypt hash",
+			password:        "hunter2",
+			hash:            string(legacy),
+			wantOK:          true,
+			wantNeedsRehash: true,
+		},
+		{
+			name:     "wrong password for legacy hash",
+			password: "hunter3",
+			hash:     string(legacy),
+		},
+		{
+			name:     "malformed PHC string",
+			password: "hunter2",
+			hash:     "$argon2id$v=19$m=1024,t=1$c2FsdA$a2V5",
+		},
+		{
+			name:     "no password set",
+			password: "",
+			hash:     "",
+		},
+		{
+			name:     "unknown algorithm",
+			password: "hunter2",
+			hash:     "$scrypt$ln=16,r=8,p=1$c2FsdA$a2V5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, needsRehash := policy.Check(tt.password, tt.hash)
+			if ok != tt.wantOK || needsRehash != tt.wantNeedsRehash {
+				t.Errorf("Check() = %v, %v; want %v, %v", ok, needsRehash, tt.wantOK, tt.wantNeedsRehash)
+			}
+		})
+	}
+}
+
+func TestArgon2idPHCFormat(t *testing.T) {
+	hash, err := testArgon2id.Hash("password")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+
+	params, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		t.Fatalf("parseArgon2id(%q) error = %v", hash, err)
+	}
+	if params.Memory != 1024 || params.Iterations != 1 || params.Parallelism != 1 {
+		t.Errorf("parseArgon2id() params = %+v, want m=1024,t=1,p=1", params)
+	}
+	if len(salt) != 16 || len(key) != 32 {
+		t.Errorf("parseArgon2id() salt %d bytes, key %d bytes; want 16 and 32", len(salt), len(key))
+	}
+}
+
+func TestCheckDummy(t *testing.T) {
+	policy := NewPasswordPolicy(testArgon2id)
+	if policy.CheckDummy("chirpy-dummy-password") {
+		t.Error("CheckDummy() = true, want always false")
+	}
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+var (
+	ErrRefreshTokenExpired = errors.New("refresh token expired")
+	ErrRefreshTokenRevoked = errors.New("refresh token revoked")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+// RefreshTokenState is what the server knows about a stored refresh token
+// at the moment a client presents it.
+type RefreshTokenState struct {
+	ExpiresAt time.Time
+	Revoked   bool
+	// Rotated is true once the token has been exchanged for a successor.
+	Rotated bool
+}
+
+// CheckRefreshToken decides whether a presented refresh token may be
+// exchanged. A rotated token showing up again means the token family has
+// leaked, which is reported as ErrRefreshTokenReused so the caller can
+// revoke the whole family.
+func CheckRefreshToken(state RefreshTokenState, now time.Time) error {
+	if state.Rotated {
+		return ErrRefreshTokenReused
+	}
+	if state.Revoked {
+		return ErrRefreshTokenRevoked
+	}
+	if !now.Before(state.ExpiresAt) {
+		return ErrRefreshTokenExpired
+	}
+	return nil
+}
+
+// HashToken returns the hex SHA-256 digest of an opaque token. Only the
+// digest is stored, so a database leak doesn't hand out usable tokens.
+// Tokens from MakeRefreshToken carry 256 bits of entropy, which makes a
+// plain, unsalted digest safe here; never use it for passwords.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckRefreshToken(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		state   RefreshTokenState
+		wantErr error
+	}{
+		{
+			name:    "active token",
+			state:   RefreshTokenState{ExpiresAt: now.Add(time.Hour)},
+			wantErr: nil,
+		},
+		{
+			name:    "expired token",
+			state:   RefreshTokenState{ExpiresAt: now.Add(-time.Hour)},
+			wantErr: ErrRefreshTokenExpired,
+		},
+		{
+			name:    "revoked token",
+			state:   RefreshTokenState{ExpiresAt: now.Add(time.Hour), Revoked: true},
+			wantErr: ErrRefreshTokenRevoked,
+		},
+		{
+			name:    "rotated token presented again",
+			state:   RefreshTokenState{ExpiresAt: now.Add(time.Hour), Revoked: true, Rotated: true},
+			wantErr: ErrRefreshTokenReused,
+		},
+		{
+			name:    "rotated token reuse wins over expiry",
+			state:   RefreshTokenState{ExpiresAt: now.Add(-time.Hour), Revoked: true, Rotated: true},
+			wantErr: ErrRefreshTokenReused,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckRefreshToken(tt.state, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("CheckRefreshToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	token, err := MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("MakeRefreshToken() error = %v", err)
+	}
+
+	hash := HashToken(token)
+	if hash == token {
+		t.Error("HashToken() returned the token unchanged")
+	}
+	if len(hash) != 64 {
+		t.Errorf("HashToken() length = %d, want 64", len(hash))
+	}
+	if HashToken(token) != hash {
+		t.Error("HashToken() is not deterministic")
+	}
+
+	// known SHA-256 digest of "abc"
+	want := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	if got := HashToken("abc"); got != want {
+		t.Errorf("HashToken(\"abc\") = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,29 @@
+package auth
+
+import "time"
+
+// Backoff is an exponential backoff policy for failed login attempts.
+// The first FreeAttempts failures cost nothing; each one after that
+// doubles the wait, starting at Base and capped at Max. A wait of Max is
+// effectively a temporary lockout.
+type Backoff struct {
+	FreeAttempts int
+	Base         time.Duration
+	Max          time.Duration
+}
+
+// Delay returns how long after the last failure the next attempt must
+// wait, given the number of consecutive failures so far.
+func (b Backoff) Delay(failures int) time.Duration {
+	if failures <= b.FreeAttempts {
+		return 0
+	}
+	delay := b.Base
+	for i := b.FreeAttempts + 1; i < failures; i++ {
+		delay *= 2
+		if delay >= b.Max {
+			return b.Max
+		}
+	}
+	return min(delay, b.Max)
+}
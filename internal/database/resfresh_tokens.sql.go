@@ -7,73 +7,255 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 const createRefreshToken = `-- name: CreateRefreshToken :one
-WITH revoke_tokens AS (
-    UPDATE refresh_tokens
-    SET 
-        revoked_at = NOW(),
-        updated_at = NOW()
-    WHERE user_id = $2 
-        AND revoked_at IS NULL
-)
 INSERT INTO refresh_tokens (
-    token,
+    token_hash,
     user_id,
+    family_id,
     created_at,
     updated_at,
     expires_at,
-    revoked_at
+    revoked_at,
+    user_agent,
+    ip_address,
+    device_name,
+    last_used_at,
+    session_started_at,
+    client_id,
+    scope
 ) VALUES (
     $1,
     $2,
+    $3,
     NOW(),
     NOW(),
     NOW() + INTERVAL '60 days',
-    NULL
-) RETURNING token, user_id, created_at, updated_at, expires_at, revoked_at
+    NULL,
+    $4,
+    $5,
+    $6,
+    NOW(),
+    $7,
+    $8,
+    $9
+) RETURNING token_hash, user_id, created_at, updated_at, expires_at, revoked_at, family_id, replaced_by_hash, user_agent, ip_address, device_name, last_used_at, session_started_at, client_id, scope
 `
 
 type CreateRefreshTokenParams struct {
-	Token  string
-	UserID uuid.UUID
+	TokenHash        string
+	UserID           uuid.UUID
+	FamilyID         uuid.UUID
+	UserAgent        string
+	IpAddress        string
+	DeviceName       sql.NullString
+	SessionStartedAt time.Time
+	ClientID         sql.NullString
+	Scope            sql.NullString
 }
 
 func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, createRefreshToken, arg.Token, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createRefreshToken,
+		arg.TokenHash,
+		arg.UserID,
+		arg.FamilyID,
+		arg.UserAgent,
+		arg.IpAddress,
+		arg.DeviceName,
+		arg.SessionStartedAt,
+		arg.ClientID,
+		arg.Scope,
+	)
+	var i RefreshToken
+	err := row.Scan(
+		&i.TokenHash,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ReplacedByHash,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.DeviceName,
+		&i.LastUsedAt,
+		&i.SessionStartedAt,
+		&i.ClientID,
+		&i.Scope,
+	)
+	return i, err
+}
+
+const getActiveSessions = `-- name: GetActiveSessions :many
+SELECT family_id, user_agent, ip_address, device_name, session_started_at, last_used_at, expires_at
+FROM refresh_tokens
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+ORDER BY last_used_at DESC
+`
+
+type GetActiveSessionsRow struct {
+	FamilyID         uuid.UUID
+	UserAgent        string
+	IpAddress        string
+	DeviceName       sql.NullString
+	SessionStartedAt time.Time
+	LastUsedAt       time.Time
+	ExpiresAt        time.Time
+}
+
+// each family has at most one unrevoked token, the session's current one
+func (q *Queries) GetActiveSessions(ctx context.Context, userID uuid.UUID) ([]GetActiveSessionsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getActiveSessions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetActiveSessionsRow
+	for rows.Next() {
+		var i GetActiveSessionsRow
+		if err := rows.Scan(
+			&i.FamilyID,
+			&i.UserAgent,
+			&i.IpAddress,
+			&i.DeviceName,
+			&i.SessionStartedAt,
+			&i.LastUsedAt,
+			&i.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRefreshToken = `-- name: GetRefreshToken :one
+SELECT token_hash, user_id, created_at, updated_at, expires_at, revoked_at, family_id, replaced_by_hash, user_agent, ip_address, device_name, last_used_at, session_started_at, client_id, scope FROM refresh_tokens WHERE token_hash = $1
+`
+
+func (q *Queries) GetRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getRefreshToken, tokenHash)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
+		&i.TokenHash,
 		&i.UserID,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ReplacedByHash,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.DeviceName,
+		&i.LastUsedAt,
+		&i.SessionStartedAt,
+		&i.ClientID,
+		&i.Scope,
 	)
 	return i, err
 }
 
+const getSessionStartedAt = `-- name: GetSessionStartedAt :one
+SELECT session_started_at FROM refresh_tokens
+WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW()
+`
+
+type GetSessionStartedAtParams struct {
+	FamilyID uuid.UUID
+	UserID   uuid.UUID
+}
+
+func (q *Queries) GetSessionStartedAt(ctx context.Context, arg GetSessionStartedAtParams) (time.Time, error) {
+	row := q.db.QueryRowContext(ctx, getSessionStartedAt, arg.FamilyID, arg.UserID)
+	var session_started_at time.Time
+	err := row.Scan(&session_started_at)
+	return session_started_at, err
+}
+
 const getUserFromRefreshToken = `-- name: GetUserFromRefreshToken :one
-SELECT token, user_id, created_at, updated_at, expires_at, revoked_at FROM refresh_tokens WHERE token = $1 AND revoked_at IS NULL
+SELECT token_hash, user_id, created_at, updated_at, expires_at, revoked_at, family_id, replaced_by_hash, user_agent, ip_address, device_name, last_used_at, session_started_at, client_id, scope FROM refresh_tokens WHERE token_hash = $1 AND revoked_at IS NULL
 `
 
-func (q *Queries) GetUserFromRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, getUserFromRefreshToken, token)
+func (q *Queries) GetUserFromRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getUserFromRefreshToken, tokenHash)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
+		&i.TokenHash,
 		&i.UserID,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ReplacedByHash,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.DeviceName,
+		&i.LastUsedAt,
+		&i.SessionStartedAt,
+		&i.ClientID,
+		&i.Scope,
 	)
 	return i, err
 }
 
+const isSessionActive = `-- name: IsSessionActive :one
+SELECT EXISTS (
+  SELECT 1 FROM refresh_tokens
+  WHERE family_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+)
+`
+
+func (q *Queries) IsSessionActive(ctx context.Context, familyID uuid.UUID) (bool, error) {
+	row := q.db.QueryRowContext(ctx, isSessionActive, familyID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const revokeOtherSessions = `-- name: RevokeOtherSessions :execrows
+UPDATE refresh_tokens
+  SET revoked_at = NOW(), updated_at = NOW()
+  WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL
+`
+
+type RevokeOtherSessionsParams struct {
+	UserID   uuid.UUID
+	FamilyID uuid.UUID
+}
+
+func (q *Queries) RevokeOtherSessions(ctx context.Context, arg RevokeOtherSessionsParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeOtherSessions, arg.UserID, arg.FamilyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revokeRefreshTokenFamily = `-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+  SET revoked_at = NOW(), updated_at = NOW()
+  WHERE family_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeRefreshTokenFamily, familyID)
+	return err
+}
+
 const revokeRefreshTokens = `-- name: RevokeRefreshTokens :exec
 UPDATE refresh_tokens
   SET revoked_at = NOW(), updated_at = NOW()
@@ -84,3 +266,41 @@ func (q *Queries) RevokeRefreshTokens(ctx context.Context, userID uuid.UUID) err
 	_, err := q.db.ExecContext(ctx, revokeRefreshTokens, userID)
 	return err
 }
+
+const revokeSession = `-- name: RevokeSession :execrows
+UPDATE refresh_tokens
+  SET revoked_at = NOW(), updated_at = NOW()
+  WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL
+`
+
+type RevokeSessionParams struct {
+	FamilyID uuid.UUID
+	UserID   uuid.UUID
+}
+
+func (q *Queries) RevokeSession(ctx context.Context, arg RevokeSessionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeSession, arg.FamilyID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const rotateRefreshToken = `-- name: RotateRefreshToken :execrows
+UPDATE refresh_tokens
+  SET revoked_at = NOW(), updated_at = NOW(), replaced_by_hash = $2
+  WHERE token_hash = $1 AND revoked_at IS NULL
+`
+
+type RotateRefreshTokenParams struct {
+	TokenHash      string
+	ReplacedByHash sql.NullString
+}
+
+func (q *Queries) RotateRefreshToken(ctx context.Context, arg RotateRefreshTokenParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, rotateRefreshToken, arg.TokenHash, arg.ReplacedByHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -7,23 +7,62 @@ package database
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+const createOIDCUser = `-- name: CreateOIDCUser :one
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, email_verified_at, handle)
+VALUES (gen_random_uuid(), now(), now(), $1, '', now(), $2)
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, role, email_verified_at, pending_email, display_name, bio, handle, avatar_url, follower_count, following_count
+`
+
+type CreateOIDCUserParams struct {
+	Email  string
+	Handle string
+}
+
+// the address was verified by the provider; an empty hash matches no
+// password, so the account can only sign in through its identity
+func (q *Queries) CreateOIDCUser(ctx context.Context, arg CreateOIDCUserParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, createOIDCUser, arg.Email, arg.Handle)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.Role,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Handle,
+		&i.AvatarUrl,
+		&i.FollowerCount,
+		&i.FollowingCount,
+	)
+	return i, err
+}
+
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password)
-VALUES ( gen_random_uuid(), now(),now(),$1,$2)
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, handle)
+VALUES ( gen_random_uuid(), now(),now(),$1,$2,$3)
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, role, email_verified_at, pending_email, display_name, bio, handle, avatar_url, follower_count, following_count
 `
 
 type CreateUserParams struct {
 	Email          string
 	HashedPassword string
+	Handle         string
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.HashedPassword)
+	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.HashedPassword, arg.Handle)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -32,6 +71,15 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.Role,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Handle,
+		&i.AvatarUrl,
+		&i.FollowerCount,
+		&i.FollowingCount,
 	)
 	return i, err
 }
@@ -46,7 +94,7 @@ func (q *Queries) DeleteUsers(ctx context.Context) error {
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users WHERE email = $1
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, role, email_verified_at, pending_email, display_name, bio, handle, avatar_url, follower_count, following_count FROM users WHERE email = $1
 `
 
 func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
@@ -59,12 +107,48 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.Role,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Handle,
+		&i.AvatarUrl,
+		&i.FollowerCount,
+		&i.FollowingCount,
+	)
+	return i, err
+}
+
+const getUserByHandle = `-- name: GetUserByHandle :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, role, email_verified_at, pending_email, display_name, bio, handle, avatar_url, follower_count, following_count FROM users WHERE lower(handle) = lower($1)
+`
+
+func (q *Queries) GetUserByHandle(ctx context.Context, lower string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByHandle, lower)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.Role,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Handle,
+		&i.AvatarUrl,
+		&i.FollowerCount,
+		&i.FollowingCount,
 	)
 	return i, err
 }
 
 const getUserByID = `-- name: GetUserByID :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users WHERE id = $1
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, role, email_verified_at, pending_email, display_name, bio, handle, avatar_url, follower_count, following_count FROM users WHERE id = $1
 `
 
 func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
@@ -77,12 +161,62 @@ func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.Role,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Handle,
+		&i.AvatarUrl,
+		&i.FollowerCount,
+		&i.FollowingCount,
 	)
 	return i, err
 }
 
+const getUserProfilesByIDs = `-- name: GetUserProfilesByIDs :many
+SELECT id, handle, display_name, avatar_url FROM users
+WHERE id = ANY($1::uuid[])
+`
+
+type GetUserProfilesByIDsRow struct {
+	ID          uuid.UUID
+	Handle      string
+	DisplayName string
+	AvatarUrl   string
+}
+
+// the public part of each user, for embedding authors in chirps
+func (q *Queries) GetUserProfilesByIDs(ctx context.Context, ids []uuid.UUID) ([]GetUserProfilesByIDsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getUserProfilesByIDs, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetUserProfilesByIDsRow
+	for rows.Next() {
+		var i GetUserProfilesByIDsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Handle,
+			&i.DisplayName,
+			&i.AvatarUrl,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getUsers = `-- name: GetUsers :many
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, role, email_verified_at, pending_email, display_name, bio, handle, avatar_url, follower_count, following_count FROM users
 `
 
 func (q *Queries) GetUsers(ctx context.Context) ([]User, error) {
@@ -101,6 +235,15 @@ func (q *Queries) GetUsers(ctx context.Context) ([]User, error) {
 			&i.Email,
 			&i.HashedPassword,
 			&i.IsChirpyRed,
+			&i.Role,
+			&i.EmailVerifiedAt,
+			&i.PendingEmail,
+			&i.DisplayName,
+			&i.Bio,
+			&i.Handle,
+			&i.AvatarUrl,
+			&i.FollowerCount,
+			&i.FollowingCount,
 		); err != nil {
 			return nil, err
 		}
@@ -115,21 +258,223 @@ func (q *Queries) GetUsers(ctx context.Context) ([]User, error) {
 	return items, nil
 }
 
-const updateUser = `-- name: UpdateUser :one
+const patchUser = `-- name: PatchUser :one
+UPDATE users
+SET display_name = COALESCE($1, display_name),
+    bio = COALESCE($2, bio),
+    handle = COALESCE($3, handle),
+    avatar_url = COALESCE($4, avatar_url),
+    hashed_password = COALESCE($5, hashed_password),
+    updated_at = now()
+WHERE id = $6
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, role, email_verified_at, pending_email, display_name, bio, handle, avatar_url, follower_count, following_count
+`
+
+type PatchUserParams struct {
+	DisplayName    sql.NullString
+	Bio            sql.NullString
+	Handle         sql.NullString
+	AvatarUrl      sql.NullString
+	HashedPassword sql.NullString
+	ID             uuid.UUID
+}
+
+// a NULL argument leaves its column as it is
+func (q *Queries) PatchUser(ctx context.Context, arg PatchUserParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, patchUser,
+		arg.DisplayName,
+		arg.Bio,
+		arg.Handle,
+		arg.AvatarUrl,
+		arg.HashedPassword,
+		arg.ID,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.Role,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Handle,
+		&i.AvatarUrl,
+		&i.FollowerCount,
+		&i.FollowingCount,
+	)
+	return i, err
+}
+
+const rehashUserPassword = `-- name: RehashUserPassword :exec
+UPDATE users
+SET hashed_password = $1
+WHERE id = $2 AND hashed_password = $3
+`
+
+type RehashUserPasswordParams struct {
+	HashedPassword    string
+	ID                uuid.UUID
+	OldHashedPassword string
+}
+
+// only replaces the hash that was checked, so a concurrent password
+// change wins
+func (q *Queries) RehashUserPassword(ctx context.Context, arg RehashUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, rehashUserPassword, arg.HashedPassword, arg.ID, arg.OldHashedPassword)
+	return err
+}
+
+const replaceAvatarURL = `-- name: ReplaceAvatarURL :exec
+UPDATE users
+SET avatar_url = $1, updated_at = now()
+WHERE avatar_url = $2
+`
+
+type ReplaceAvatarURLParams struct {
+	NewUrl string
+	OldUrl string
+}
+
+func (q *Queries) ReplaceAvatarURL(ctx context.Context, arg ReplaceAvatarURLParams) error {
+	_, err := q.db.ExecContext(ctx, replaceAvatarURL, arg.NewUrl, arg.OldUrl)
+	return err
+}
+
+const setUserPendingEmail = `-- name: SetUserPendingEmail :one
+UPDATE users
+SET pending_email = $2, updated_at = now()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, role, email_verified_at, pending_email, display_name, bio, handle, avatar_url, follower_count, following_count
+`
+
+type SetUserPendingEmailParams struct {
+	ID           uuid.UUID
+	PendingEmail sql.NullString
+}
+
+func (q *Queries) SetUserPendingEmail(ctx context.Context, arg SetUserPendingEmailParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserPendingEmail, arg.ID, arg.PendingEmail)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.Role,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Handle,
+		&i.AvatarUrl,
+		&i.FollowerCount,
+		&i.FollowingCount,
+	)
+	return i, err
+}
+
+const updateUserPassword = `-- name: UpdateUserPassword :exec
 UPDATE users
-SET email = $2, hashed_password = $3, updated_at = now()
+SET hashed_password = $2, updated_at = now()
 WHERE id = $1
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
 `
 
-type UpdateUserParams struct {
+type UpdateUserPasswordParams struct {
 	ID             uuid.UUID
-	Email          string
 	HashedPassword string
 }
 
-func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, updateUser, arg.ID, arg.Email, arg.HashedPassword)
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserPassword, arg.ID, arg.HashedPassword)
+	return err
+}
+
+const updateUserRole = `-- name: UpdateUserRole :one
+UPDATE users
+SET role = $2, updated_at = now()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, role, email_verified_at, pending_email, display_name, bio, handle, avatar_url, follower_count, following_count
+`
+
+type UpdateUserRoleParams struct {
+	ID   uuid.UUID
+	Role string
+}
+
+func (q *Queries) UpdateUserRole(ctx context.Context, arg UpdateUserRoleParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserRole, arg.ID, arg.Role)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.Role,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Handle,
+		&i.AvatarUrl,
+		&i.FollowerCount,
+		&i.FollowingCount,
+	)
+	return i, err
+}
+
+const upgradeToChirpyRed = `-- name: UpgradeToChirpyRed :one
+UPDATE users
+SET is_chirpy_red = true, updated_at = now()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, role, email_verified_at, pending_email, display_name, bio, handle, avatar_url, follower_count, following_count
+`
+
+func (q *Queries) UpgradeToChirpyRed(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, upgradeToChirpyRed, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.Role,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Handle,
+		&i.AvatarUrl,
+		&i.FollowerCount,
+		&i.FollowingCount,
+	)
+	return i, err
+}
+
+const verifyUserEmail = `-- name: VerifyUserEmail :one
+UPDATE users
+SET email = $2, email_verified_at = now(), pending_email = NULL, updated_at = now()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, role, email_verified_at, pending_email, display_name, bio, handle, avatar_url, follower_count, following_count
+`
+
+type VerifyUserEmailParams struct {
+	ID    uuid.UUID
+	Email string
+}
+
+func (q *Queries) VerifyUserEmail(ctx context.Context, arg VerifyUserEmailParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, verifyUserEmail, arg.ID, arg.Email)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -138,6 +483,15 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.Role,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Handle,
+		&i.AvatarUrl,
+		&i.FollowerCount,
+		&i.FollowingCount,
 	)
 	return i, err
 }
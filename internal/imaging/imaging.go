@@ -0,0 +1,157 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+
+	// registered with image.Decode
+	_ "image/gif"
+	_ "image/png"
+)
+
+// MaxPixels bounds the decoded size of an image. A few kilobytes of PNG
+// can claim to be gigapixels, so the header is checked before decoding.
+const MaxPixels = 40_000_000
+const MaxDimension = 12_000
+
+const jpegQuality = 85
+
+var ErrTooLarge = errors.New("image dimensions are too large")
+var ErrUnsupported = errors.New("unsupported image format")
+
+// Size is a rendition to generate: the image scaled to fit in a
+// MaxDimension square. Smaller images are never scaled up.
+type Size struct {
+	Name         string
+	MaxDimension int
+}
+
+var DefaultSizes = []Size{
+	{Name: "thumb", MaxDimension: 320},
+	{Name: "medium", MaxDimension: 1024},
+	{Name: "large", MaxDimension: 2048},
+}
+
+// Rendition is an encoded JPEG.
+type Rendition struct {
+	Name   string
+	Width  int
+	Height int
+	Data   []byte
+}
+
+// Result describes a processed image; Width and Height are those of the
+// upright original.
+type Result struct {
+	Width      int
+	Height     int
+	Renditions []Rendition
+}
+
+// Process normalizes a JPEG, PNG or GIF into one JPEG per size. The image
+// is turned upright according to its EXIF orientation, transparency is
+// flattened onto white, and only the first frame of an animation is kept.
+// Re-encoding leaves every bit of metadata, GPS position included, behind.
+func Process(data []byte, sizes []Size) (Result, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) {
+		return Result{}, ErrUnsupported
+	}
+	if err != nil {
+		return Result{}, fmt.Errorf("reading image header: %w", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 ||
+		config.Width > MaxDimension || config.Height > MaxDimension ||
+		config.Width*config.Height > MaxPixels {
+		return Result{}, ErrTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Result{}, fmt.Errorf("decoding image: %w", err)
+	}
+
+	canvas := flatten(img)
+	if format == "jpeg" {
+		canvas = orient(canvas, jpegOrientation(data))
+	}
+	width, height := canvas.Bounds().Dx(), canvas.Bounds().Dy()
+
+	result := Result{Width: width, Height: height}
+	for _, size := range sizes {
+		w, h := fit(width, height, size.MaxDimension)
+		scaled := canvas
+		if w != width || h != height {
+			scaled = resize(canvas, w, h)
+		}
+
+		var buf bytes.Buffer
+		err := jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: jpegQuality})
+		if err != nil {
+			return Result{}, fmt.Errorf("encoding %s: %w", size.Name, err)
+		}
+		result.Renditions = append(result.Renditions, Rendition{
+			Name:   size.Name,
+			Width:  w,
+			Height: h,
+			Data:   buf.Bytes(),
+		})
+	}
+	return result, nil
+}
+
+// flatten draws img onto an opaque white canvas whose origin is (0, 0).
+func flatten(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	canvas := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(canvas, canvas.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(canvas, canvas.Bounds(), img, bounds.Min, draw.Over)
+	return canvas
+}
+
+// fit scales width by height down to fit in a square of side maxDimension,
+// keeping the aspect ratio.
+func fit(width, height, maxDimension int) (int, int) {
+	if width <= maxDimension && height <= maxDimension {
+		return width, height
+	}
+	if width >= height {
+		return maxDimension, max(1, (height*maxDimension+width/2)/width)
+	}
+	return max(1, (width*maxDimension+height/2)/height), maxDimension
+}
+
+// resize scales src down with a box filter: every destination pixel is the
+// average of the source pixels it covers.
+func resize(src *image.RGBA, width, height int) *image.RGBA {
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := y * srcHeight / height
+		y1 := max((y+1)*srcHeight/height, y0+1)
+		for x := 0; x < width; x++ {
+			x0 := x * srcWidth / width
+			x1 := max((x+1)*srcWidth/width, x0+1)
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(row[sx*4+c])
+					}
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			offset := y*dst.Stride + x*4
+			for c := 0; c < 4; c++ {
+				dst.Pix[offset+c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+	return dst
+}
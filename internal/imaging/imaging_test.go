@@ -0,0 +1,289 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// testImage is red on the left half and blue on the right, so rotations
+// are easy to tell apart.
+func testImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= width/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatalf("jpeg.Encode() error = %v", err)
+	}
+	return buf.Bytes()
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode() error = %v", err)
+	}
+	return buf.Bytes()
+}
+
+// withEXIF inserts an APP1 segment with an orientation tag and a GPS IFD
+// pointer right after the SOI marker of a JPEG.
+func withEXIF(jpegData []byte, order binary.ByteOrder, orientation uint16) []byte {
+	var tiff bytes.Buffer
+	if order == binary.LittleEndian {
+		tiff.WriteString("II")
+	} else {
+		tiff.WriteString("MM")
+	}
+	binary.Write(&tiff, order, uint16(42))
+	binary.Write(&tiff, order, uint32(8))
+	binary.Write(&tiff, order, uint16(2))
+	// orientation, SHORT, count 1
+	binary.Write(&tiff, order, []uint16{0x0112, 3})
+	binary.Write(&tiff, order, uint32(1))
+	binary.Write(&tiff, order, []uint16{orientation, 0})
+	// GPSInfo, LONG, count 1
+	binary.Write(&tiff, order, []uint16{0x8825, 4})
+	binary.Write(&tiff, order, []uint32{1, 0})
+	binary.Write(&tiff, order, uint32(0))
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	out := append([]byte{}, jpegData[:2]...)
+	out = append(out, app1...)
+	return append(out, jpegData[2:]...)
+}
+
+// pngHeader is a PNG that is nothing but a signature and an IHDR chunk
+// claiming the given size, like the start of a decompression bomb.
+func pngHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], width)
+	binary.BigEndian.PutUint32(ihdr[4:], height)
+	ihdr[8] = 8 // bit depth
+	ihdr[9] = 6 // RGBA
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(len(ihdr)))
+	chunk := append([]byte("IHDR"), ihdr...)
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	plain := encodeJPEG(t, testImage(8, 4))
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{name: "no EXIF", data: plain, want: 1},
+		{name: "little endian", data: withEXIF(plain, binary.LittleEndian, 6), want: 6},
+		{name: "big endian", data: withEXIF(plain, binary.BigEndian, 8), want: 8},
+		{name: "out of range", data: withEXIF(plain, binary.BigEndian, 9), want: 1},
+		{name: "truncated", data: withEXIF(plain, binary.BigEndian, 6)[:30], want: 1},
+		{name: "not a JPEG", data: []byte("GIF89a"), want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jpegOrientation(tt.data); got != tt.want {
+				t.Errorf("jpegOrientation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// 3x2 with distinct pixel values: 0 1 2 / 3 4 5
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i := 0; i < 6; i++ {
+		src.Pix[i*4] = uint8(i)
+	}
+
+	tests := []struct {
+		orientation int
+		want        [][]uint8
+	}{
+		{orientation: 1, want: [][]uint8{{0, 1, 2}, {3, 4, 5}}},
+		{orientation: 2, want: [][]uint8{{2, 1, 0}, {5, 4, 3}}},
+		{orientation: 3, want: [][]uint8{{5, 4, 3}, {2, 1, 0}}},
+		{orientation: 4, want: [][]uint8{{3, 4, 5}, {0, 1, 2}}},
+		{orientation: 5, want: [][]uint8{{0, 3}, {1, 4}, {2, 5}}},
+		{orientation: 6, want: [][]uint8{{3, 0}, {4, 1}, {5, 2}}},
+		{orientation: 7, want: [][]uint8{{5, 2}, {4, 1}, {3, 0}}},
+		{orientation: 8, want: [][]uint8{{2, 5}, {1, 4}, {0, 3}}},
+	}
+
+	for _, tt := range tests {
+		dst := orient(src, tt.orientation)
+		for y, row := range tt.want {
+			for x, want := range row {
+				if got := dst.Pix[y*dst.Stride+x*4]; got != want {
+					t.Errorf("orient(%d) pixel (%d, %d) = %d, want %d", tt.orientation, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestProcess(t *testing.T) {
+	transparent := image.NewNRGBA(image.Rect(0, 0, 40, 40))
+
+	frame := image.NewPaletted(image.Rect(0, 0, 50, 20), color.Palette{color.Black, color.White})
+	var animated bytes.Buffer
+	err := gif.EncodeAll(&animated, &gif.GIF{
+		Image: []*image.Paletted{frame, frame},
+		Delay: []int{10, 10},
+	})
+	if err != nil {
+		t.Fatalf("gif.EncodeAll() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		data       []byte
+		wantErr    error
+		wantWidth  int
+		wantHeight int
+		wantSizes  [][2]int
+	}{
+		{
+			name:       "large JPEG",
+			data:       encodeJPEG(t, testImage(3000, 1500)),
+			wantWidth:  3000,
+			wantHeight: 1500,
+			wantSizes:  [][2]int{{320, 160}, {1024, 512}, {2048, 1024}},
+		},
+		{
+			name:       "rotated JPEG",
+			data:       withEXIF(encodeJPEG(t, testImage(400, 200)), binary.LittleEndian, 6),
+			wantWidth:  200,
+			wantHeight: 400,
+			wantSizes:  [][2]int{{160, 320}, {200, 400}, {200, 400}},
+		},
+		{
+			name:       "transparent PNG",
+			data:       encodePNG(t, transparent),
+			wantWidth:  40,
+			wantHeight: 40,
+			wantSizes:  [][2]int{{40, 40}, {40, 40}, {40, 40}},
+		},
+		{
+			name:       "animated GIF",
+			data:       animated.Bytes(),
+			wantWidth:  50,
+			wantHeight: 20,
+			wantSizes:  [][2]int{{50, 20}, {50, 20}, {50, 20}},
+		},
+		{
+			name:    "decompression bomb",
+			data:    pngHeader(100_000, 100_000),
+			wantErr: ErrTooLarge,
+		},
+		{
+			name:    "too many pixels",
+			data:    pngHeader(10_000, 10_000),
+			wantErr: ErrTooLarge,
+		},
+		{
+			name:    "not an image",
+			data:    []byte("<svg xmlns='http://www.w3.org/2000/svg'/>"),
+			wantErr: ErrUnsupported,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Process(tt.data, DefaultSizes)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Process() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if result.Width != tt.wantWidth || result.Height != tt.wantHeight {
+				t.Errorf("Process() size = %dx%d, want %dx%d", result.Width, result.Height, tt.wantWidth, tt.wantHeight)
+			}
+			if len(result.Renditions) != len(tt.wantSizes) {
+				t.Fatalf("Process() made %d renditions, want %d", len(result.Renditions), len(tt.wantSizes))
+			}
+			for i, rendition := range result.Renditions {
+				img, err := jpeg.Decode(bytes.NewReader(rendition.Data))
+				if err != nil {
+					t.Fatalf("rendition %s isn't a JPEG: %v", rendition.Name, err)
+				}
+				got := [2]int{img.Bounds().Dx(), img.Bounds().Dy()}
+				if got != tt.wantSizes[i] || got != [2]int{rendition.Width, rendition.Height} {
+					t.Errorf("rendition %s is %v, want %v", rendition.Name, got, tt.wantSizes[i])
+				}
+				if bytes.Contains(rendition.Data, []byte("Exif")) {
+					t.Errorf("rendition %s kept the EXIF data", rendition.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessFlattensOntoWhite(t *testing.T) {
+	result, err := Process(encodePNG(t, image.NewNRGBA(image.Rect(0, 0, 8, 8))), DefaultSizes[:1])
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(result.Renditions[0].Data))
+	if err != nil {
+		t.Fatalf("jpeg.Decode() error = %v", err)
+	}
+	r, g, b, _ := img.At(4, 4).RGBA()
+	if r>>8 < 250 || g>>8 < 250 || b>>8 < 250 {
+		t.Errorf("transparent pixel became %d,%d,%d, want white", r>>8, g>>8, b>>8)
+	}
+}
+
+func TestProcessKeepsRotation(t *testing.T) {
+	// red on the left turns into red on top after a 90° clockwise turn
+	data := withEXIF(encodeJPEG(t, testImage(64, 32)), binary.BigEndian, 6)
+	result, err := Process(data, []Size{{Name: "full", MaxDimension: 64}})
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(result.Renditions[0].Data))
+	if err != nil {
+		t.Fatalf("jpeg.Decode() error = %v", err)
+	}
+	top, _, _, _ := img.At(16, 8).RGBA()
+	bottom, _, _, _ := img.At(16, 56).RGBA()
+	if top>>8 < 200 || bottom>>8 > 50 {
+		t.Errorf("red channel top = %d, bottom = %d; image wasn't rotated clockwise", top>>8, bottom>>8)
+	}
+}
@@ -0,0 +1,96 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildMessage(t *testing.T) {
+	tests := []struct {
+		name         string
+		msg          Message
+		wantErr      bool
+		wantContains []string
+	}{
+		{
+			name: "plain message",
+			msg: Message{
+				To:      "user@example.com",
+				Subject: "Reset your password",
+				Body:    "line one\nline two",
+			},
+			wantContains: []string{
+				"From: chirpy@example.com\r\n",
+				"To: user@example.com\r\n",
+				"Subject: Reset your password\r\n",
+				"Content-Type: text/plain; charset=UTF-8\r\n",
+				"\r\n\r\nline one\r\nline two",
+			},
+		},
+		{
+			name: "header injection in subject",
+			msg: Message{
+				To:      "user@example.com",
+				Subject: "hi\r\nBcc: victim@example.com",
+			},
+			wantErr: true,
+		},
+		{
+			name: "header injection in recipient",
+			msg: Message{
+				To: "user@example.com\nBcc: victim@example.com",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := buildMessage("chirpy@example.com", tt.msg, time.Now())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildMessage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, want := range tt.wantContains {
+				if !strings.Contains(string(data), want) {
+					t.Errorf("buildMessage() = %q, should contain %q", data, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryMailer(t *testing.T) {
+	m := NewMemoryMailer()
+	msg := Message{To: "user@example.com", Subject: "hello", Body: "body"}
+	if err := m.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	got := m.Messages()
+	if len(got) != 1 || got[0] != msg {
+		t.Fatalf("Messages() = %+v, want [%+v]", got, msg)
+	}
+
+	// the returned slice is a copy
+	got[0].To = "changed"
+	if m.Messages()[0].To != "user@example.com" {
+		t.Error("Messages() exposed internal state")
+	}
+}
+
+func TestLogMailer(t *testing.T) {
+	var out bytes.Buffer
+	m := NewLogMailer(&out)
+	err := m.Send(context.Background(), Message{To: "user@example.com", Subject: "hello", Body: "the token is abc"})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	for _, want := range []string{"user@example.com", "hello", "the token is abc"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("LogMailer output = %q, should contain %q", out.String(), want)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID     = "chirpy"
+	testClientSecret = "s3cret"
+	testRedirectURL  = "https://chirpy.example/api/oidc/fake/callback"
+)
+
+// fakeIdP is an in-process OpenID provider. It hands out one code at a
+// time, and the ID token for it is built by the test's claims function.
+type fakeIdP struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	keyID         string
+	key           *rsa.PrivateKey
+	issuer        string
+	code          string
+	codeChallenge string
+	nonce         string
+	jwksFetches   int
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+	idp := &fakeIdP{keyID: "key-1", key: newRSAKey(t)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.issuer,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		idp.jwksFetches++
+		json.NewEncoder(w).Encode(map[string]any{"keys": []jwk{rsaJWK(idp.keyID, &idp.key.PublicKey)}})
+	})
+	mux.HandleFunc("POST /token", idp.handleToken)
+	idp.Server = httptest.NewServer(mux)
+	idp.issuer = idp.URL
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *fakeIdP) handleToken(w http.ResponseWriter, r *http.Request) {
+	clientID, secret, _ := r.BasicAuth()
+	if clientID != testClientID || secret != testClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if r.PostFormValue("code") != idp.code ||
+		r.PostFormValue("redirect_uri") != testRedirectURL ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != idp.codeChallenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "opaque",
+		"token_type":   "Bearer",
+		"id_token":     idp.sign(idp.claims()),
+	})
+}
+
+func (idp *fakeIdP) claims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            idp.issuer,
+		"sub":            "user-42",
+		"aud":            testClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          idp.nonce,
+		"email":          "walt@example.com",
+		"email_verified": true,
+		"name":           "Walter",
+	}
+}
+
+func (idp *fakeIdP) sign(claims jwt.MapClaims) string {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = idp.keyID
+	signed, err := token.SignedString(idp.key)
+	if err != nil {
+		panic(err)
+	}
+	return signed
+}
+
+func (idp *fakeIdP) rotateKey(t *testing.T, keyID string) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.keyID = keyID
+	idp.key = newRSAKey(t)
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	return key
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) jwk {
+	return jwk{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func newTestProvider(idp *fakeIdP) *Provider {
+	return NewProvider(Config{
+		Issuer:       idp.issuer,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+	}, idp.Client())
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	idp := newFakeIdP(t)
+	provider := newTestProvider(idp)
+	ctx := context.Background()
+
+	verifier := strings.Repeat("v", 43)
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", challenge)
+	if err != nil {
+		t.Fatalf("AuthCodeURL() error = %v", err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("url.Parse() error = %v", err)
+	}
+	query := u.Query()
+	if u.Path != "/authorize" || query.Get("state") != "state-1" || query.Get("nonce") != "nonce-1" ||
+		query.Get("code_challenge") != challenge || query.Get("scope") != "openid email profile" {
+		t.Fatalf("AuthCodeURL() = %s", authURL)
+	}
+
+	// the user signs in at the provider, which redirects back with a code
+	idp.code = "code-1"
+	idp.codeChallenge = query.Get("code_challenge")
+	idp.nonce = query.Get("nonce")
+
+	idToken, err := provider.Exchange(ctx, "code-1", verifier, "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+	want := IDToken{
+		Issuer:        idp.issuer,
+		Subject:       "user-42",
+		Email:         "walt@example.com",
+		EmailVerified: true,
+		Name:          "Walter",
+	}
+	if idToken != want {
+		t.Errorf("Exchange() = %+v, want %+v", idToken, want)
+	}
+
+	_, err = provider.Exchange(ctx, "code-1", strings.Repeat("x", 43), "nonce-1")
+	if err == nil {
+		t.Error("Exchange() with the wrong verifier succeeded")
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	idp := newFakeIdP(t)
+	provider := newTestProvider(idp)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		token   func() string
+		wantErr bool
+	}{
+		{
+			name:  "valid",
+			token: func() string { return idp.sign(idp.claims()) },
+		},
+		{
+			name: "email_verified as a string",
+			token: func() string {
+				claims := idp.claims()
+				claims["email_verified"] = "true"
+				return idp.sign(claims)
+			},
+		},
+		{
+			name: "wrong nonce",
+			token: func() string {
+				claims := idp.claims()
+				claims["nonce"] = "other"
+				return idp.sign(claims)
+			},
+			wantErr: true,
+		},
+		{
+			name: "wrong audience",
+			token: func() string {
+				claims := idp.claims()
+				claims["aud"] = "someone-else"
+				return idp.sign(claims)
+			},
+			wantErr: true,
+		},
+		{
+			name: "several audiences without azp",
+			token: func() string {
+				claims := idp.claims()
+				claims["aud"] = []string{testClientID, "someone-else"}
+				return idp.sign(claims)
+			},
+			wantErr: true,
+		},
+		{
+			name: "wrong issuer",
+			token: func() string {
+				claims := idp.claims()
+				claims["iss"] = "https://evil.example"
+				return idp.sign(claims)
+			},
+			wantErr: true,
+		},
+		{
+			name: "expired",
+			token: func() string {
+				claims := idp.claims()
+				claims["exp"] = time.Now().Add(-time.Hour).Unix()
+				return idp.sign(claims)
+			},
+			wantErr: true,
+		},
+		{
+			name: "no expiry",
+			token: func() string {
+				claims := idp.claims()
+				delete(claims, "exp")
+				return idp.sign(claims)
+			},
+			wantErr: true,
+		},
+		{
+			name: "signed by an unknown key",
+			token: func() string {
+				token := jwt.NewWithClaims(jwt.SigningMethodES256, idp.claims())
+				token.Header["kid"] = idp.keyID
+				signed, _ := token.SignedString(otherKey)
+				return signed
+			},
+			wantErr: true,
+		},
+		{
+			name: "unsigned",
+			token: func() string {
+				token := jwt.NewWithClaims(jwt.SigningMethodNone, idp.claims())
+				signed, _ := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+				return signed
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := provider.VerifyIDToken(context.Background(), tt.token(), "")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyIDToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenKeyRotation(t *testing.T) {
+	idp := newFakeIdP(t)
+	provider := newTestProvider(idp)
+	ctx := context.Background()
+
+	_, err := provider.VerifyIDToken(ctx, idp.sign(idp.claims()), "")
+	if err != nil {
+		t.Fatalf("VerifyIDToken() error = %v", err)
+	}
+
+	// a new kid is fetched as soon as the refresh interval allows
+	idp.rotateKey(t, "key-2")
+	provider.keysFetchedAt = time.Now().Add(-keysRefreshInterval)
+	_, err = provider.VerifyIDToken(ctx, idp.sign(idp.claims()), "")
+	if err != nil {
+		t.Fatalf("VerifyIDToken() after rotation error = %v", err)
+	}
+
+	// but not more often than that
+	idp.rotateKey(t, "key-3")
+	_, err = provider.VerifyIDToken(ctx, idp.sign(idp.claims()), "")
+	if err == nil {
+		t.Error("VerifyIDToken() refetched the JWKS within the refresh interval")
+	}
+	if idp.jwksFetches != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", idp.jwksFetches)
+	}
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	idp := newFakeIdP(t)
+	idp.issuer = "https://impostor.example"
+	provider := NewProvider(Config{Issuer: idp.URL, ClientID: testClientID}, idp.Client())
+
+	_, err := provider.Discover(context.Background())
+	if err == nil {
+		t.Error("Discover() accepted a document for another issuer")
+	}
+}
@@ -0,0 +1,226 @@
+package qrcode
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// "HELLO WORLD" at 1-M, from the worked example in the specification
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+	got := reedSolomonRemainder(data, reedSolomonDivisor(len(want)))
+	if !bytes.Equal(got, want) {
+		t.Errorf("reedSolomonRemainder() = %v, want %v", got, want)
+	}
+}
+
+func TestFormatBits(t *testing.T) {
+	tests := []struct {
+		mask int
+		want int
+	}{
+		{mask: 0, want: 0b101010000010010},
+		{mask: 4, want: 0b100010111111001},
+		{mask: 7, want: 0b100101010100000},
+	}
+
+	for _, tt := range tests {
+		if got := formatBits(tt.mask); got != tt.want {
+			t.Errorf("formatBits(%d) = %015b, want %015b", tt.mask, got, tt.want)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		wantVersion int
+		wantErr     bool
+	}{
+		{
+			name:        "short",
+			content:     "chirpy",
+			wantVersion: 1,
+		},
+		{
+			name:        "otpauth uri",
+			content:     "otpauth://totp/Chirpy:walt%40breakingbad.com?secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP&issuer=Chirpy&algorithm=SHA1&digits=6&period=30",
+			wantVersion: 8,
+		},
+		{
+			name:        "largest version",
+			content:     strings.Repeat("a", 213),
+			wantVersion: 10,
+		},
+		{
+			name:    "too long",
+			content: strings.Repeat("a", 214),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Encode(tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Encode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if code.Version != tt.wantVersion {
+				t.Errorf("Encode() version = %d, want %d", code.Version, tt.wantVersion)
+			}
+			if code.Size != tt.wantVersion*4+17 {
+				t.Errorf("Encode() size = %d, want %d", code.Size, tt.wantVersion*4+17)
+			}
+
+			got := readBack(t, code)
+			if got != tt.content {
+				t.Errorf("read back %q, want %q", got, tt.content)
+			}
+		})
+	}
+}
+
+func TestPNG(t *testing.T) {
+	code, err := Encode("chirpy")
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	data, err := code.PNG(4)
+	if err != nil {
+		t.Fatalf("PNG() error = %v", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("png.Decode() error = %v", err)
+	}
+	side := (code.Size + 2*quietZone) * 4
+	if img.Bounds().Dx() != side || img.Bounds().Dy() != side {
+		t.Errorf("image size = %v, want %dx%d", img.Bounds(), side, side)
+	}
+
+	// the quiet zone is light, the top left finder corner is dark
+	if r, _, _, _ := img.At(0, 0).RGBA(); r == 0 {
+		t.Error("quiet zone is dark")
+	}
+	if r, _, _, _ := img.At(quietZone*4, quietZone*4).RGBA(); r != 0 {
+		t.Error("finder corner is light")
+	}
+}
+
+// readBack decodes a symbol the way a scanner would once it has located
+// it: read the format bits, unmask, collect the codewords, deinterleave
+// and parse the byte mode segment.
+func readBack(t *testing.T, code *Code) string {
+	t.Helper()
+
+	format := 0
+	for i := 14; i >= 9; i-- {
+		format = format<<1 | bit(code.Dark(14-i, 8))
+	}
+	format = format<<1 | bit(code.Dark(7, 8))
+	format = format<<1 | bit(code.Dark(8, 8))
+	format = format<<1 | bit(code.Dark(8, 7))
+	for i := 5; i >= 0; i-- {
+		format = format<<1 | bit(code.Dark(8, i))
+	}
+	mask := -1
+	for m := 0; m < 8; m++ {
+		if formatBits(m) == format {
+			mask = m
+		}
+	}
+	if mask < 0 {
+		t.Fatalf("format bits %015b match no mask at level M", format)
+	}
+
+	// rebuild the function pattern map for this version
+	layout := newCode(code.Version)
+	layout.drawFunctionPatterns()
+
+	raw := []byte{}
+	n := 0
+	for right := code.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < code.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = code.Size - 1 - vert
+				}
+				if layout.isFunc[y][x] {
+					continue
+				}
+				if n%8 == 0 {
+					raw = append(raw, 0)
+				}
+				if code.Dark(x, y) != maskBit(mask, x, y) {
+					raw[n/8] |= 1 << (7 - n%8)
+				}
+				n++
+			}
+		}
+	}
+
+	v := versionsM[code.Version]
+	blocks := make([][]byte, v.blocks1+v.blocks2)
+	i := 0
+	for k := 0; k < max(v.data1, v.data2); k++ {
+		for b := range blocks {
+			size := v.data1
+			if b >= v.blocks1 {
+				size = v.data2
+			}
+			if k < size {
+				blocks[b] = append(blocks[b], raw[i])
+				i++
+			}
+		}
+	}
+	data := []byte{}
+	for _, block := range blocks {
+		data = append(data, block...)
+	}
+
+	// check the interleaved error correction against the data
+	if want := interleave(data, code.Version); !bytes.Equal(raw[:len(want)], want) {
+		t.Fatal("error correction codewords do not match the data")
+	}
+
+	if data[0]>>4 != 0b0100 {
+		t.Fatalf("mode = %04b, want byte mode", data[0]>>4)
+	}
+	pos := 4
+	read := func(n int) int {
+		value := 0
+		for k := 0; k < n; k++ {
+			value = value<<1 | int(data[pos/8]>>(7-pos%8)&1)
+			pos++
+		}
+		return value
+	}
+	length := read(countBits(code.Version))
+	out := make([]byte, length)
+	for k := range out {
+		out[k] = byte(read(8))
+	}
+	return string(out)
+}
+
+func bit(dark bool) int {
+	if dark {
+		return 1
+	}
+	return 0
+}
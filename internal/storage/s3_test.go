@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testAccessKeyID     = "AKIDEXAMPLE"
+	testSecretAccessKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+)
+
+// the get-vanilla case of the AWS Signature Version 4 test suite
+func TestSignV4(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	signV4(req, sha256Hex(""), testAccessKeyID, testSecretAccessKey, "us-east-1", "service", now)
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %s, want %s", got, want)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("X-Amz-Date = %s", got)
+	}
+}
+
+func TestCanonicalURI(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "", want: "/"},
+		{path: "/bucket/media/a.png", want: "/bucket/media/a.png"},
+		{path: "/bucket/a%20b", want: "/bucket/a%20b"},
+		{path: "/bucket/a+b~c", want: "/bucket/a%2Bb~c"},
+	}
+
+	for _, tt := range tests {
+		if got := canonicalURI(tt.path); got != tt.want {
+			t.Errorf("canonicalURI(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+// fakeS3 is a MinIO-style stand-in that checks every request's signature
+// the way S3 does and keeps objects in memory.
+type fakeS3 struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+}
+
+func newFakeS3(t *testing.T) *fakeS3 {
+	t.Helper()
+	s3 := &fakeS3{objects: map[string]fakeObject{}}
+	s3.Server = httptest.NewServer(http.HandlerFunc(s3.serve))
+	t.Cleanup(s3.Close)
+	return s3
+}
+
+func (s3 *fakeS3) serve(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	sum := sha256.Sum256(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+		s3.fail(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch")
+		return
+	}
+
+	// sign the request again with the secret and compare
+	signedAt, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		s3.fail(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	check := r.Clone(r.Context())
+	check.URL.Host = r.Host
+	check.Header.Del("Authorization")
+	for name := range check.Header {
+		lower := strings.ToLower(name)
+		if lower != "content-type" && !strings.HasPrefix(lower, "x-amz-") {
+			check.Header.Del(name)
+		}
+	}
+	signV4(check, r.Header.Get("X-Amz-Content-Sha256"), testAccessKeyID, testSecretAccessKey, "us-east-1", "s3", signedAt)
+	if check.Header.Get("Authorization") != r.Header.Get("Authorization") {
+		s3.fail(w, http.StatusForbidden, "SignatureDoesNotMatch")
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != "chirpy" {
+		s3.fail(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	s3.mu.Lock()
+	defer s3.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		s3.objects[key] = fakeObject{data: body, contentType: r.Header.Get("Content-Type")}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		object, ok := s3.objects[key]
+		if !ok {
+			s3.fail(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", object.contentType)
+		w.Write(object.data)
+	case http.MethodDelete:
+		delete(s3.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s3 *fakeS3) fail(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, "<Error><Code>"+code+"</Code></Error>")
+}
+
+func newTestS3Store(s3 *fakeS3, secret string) *S3Store {
+	return NewS3Store(S3Config{
+		Endpoint:        s3.URL + "/",
+		Region:          "us-east-1",
+		Bucket:          "chirpy",
+		AccessKeyID:     testAccessKeyID,
+		SecretAccessKey: secret,
+	}, s3.Client())
+}
+
+func TestS3Store(t *testing.T) {
+	s3 := newFakeS3(t)
+	testStore(t, newTestS3Store(s3, testSecretAccessKey))
+}
+
+func TestS3StoreWrongSecret(t *testing.T) {
+	s3 := newFakeS3(t)
+	store := newTestS3Store(s3, "not-the-secret")
+
+	err := store.Put(context.Background(), "media/a.png", []byte("data"), "image/png")
+	if err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Errorf("Put() error = %v, want SignatureDoesNotMatch", err)
+	}
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+)
+
+var ErrNotFound = errors.New("blob not found")
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// Blob is an object read back from a BlobStore. The caller must close
+// Body.
+type Blob struct {
+	Body        io.ReadCloser
+	ContentType string
+	Size        int64
+}
+
+// BlobStore keeps uploaded files such as avatars and chirp media. Keys
+// are slash separated paths like "media/ab/abcdef.png".
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) (Blob, error)
+	Delete(ctx context.Context, key string) error
+}
+
+var keyPattern = regexp.MustCompile(`^[a-z0-9_-]+(\.[a-z0-9]+)?(/[a-z0-9_-]+(\.[a-z0-9]+)?)*$`)
+
+// ValidateKey rejects keys that could escape the store's directory or
+// bucket, such as ones containing "..". Only lowercase letters, digits,
+// "-", "_" and a single extension per path segment are allowed.
+func ValidateKey(key string) error {
+	if len(key) > 512 || !keyPattern.MatchString(key) {
+		return ErrInvalidKey
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateKey(t *testing.T) {
+	tests := []struct {
+		key     string
+		wantErr bool
+	}{
+		{key: "media/ab/abcdef0123.png"},
+		{key: "avatar_1.jpg"},
+		{key: "", wantErr: true},
+		{key: "../etc/passwd", wantErr: true},
+		{key: "media/../../secret", wantErr: true},
+		{key: "/absolute.png", wantErr: true},
+		{key: "media//double.png", wantErr: true},
+		{key: "media/trailing/", wantErr: true},
+		{key: "Media/Upper.PNG", wantErr: true},
+		{key: "media/a b.png", wantErr: true},
+		{key: "media/two.tar.gz", wantErr: true},
+		{key: `media\windows.png`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			err := ValidateKey(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateKey(%q) error = %v, wantErr %v", tt.key, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// testStore runs the behaviour every BlobStore must share.
+func testStore(t *testing.T, store BlobStore) {
+	t.Helper()
+	ctx := context.Background()
+	data := []byte("\x89PNG not really")
+
+	err := store.Put(ctx, "media/ab/abcdef.png", data, "image/png")
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	blob, err := store.Get(ctx, "media/ab/abcdef.png")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	got, err := io.ReadAll(blob.Body)
+	blob.Body.Close()
+	if err != nil {
+		t.Fatalf("reading blob: %v", err)
+	}
+	if string(got) != string(data) || blob.ContentType != "image/png" || blob.Size != int64(len(data)) {
+		t.Errorf("Get() = %q, %q, %d", got, blob.ContentType, blob.Size)
+	}
+
+	err = store.Delete(ctx, "media/ab/abcdef.png")
+	if err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	_, err = store.Get(ctx, "media/ab/abcdef.png")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after Delete() error = %v, want ErrNotFound", err)
+	}
+	err = store.Delete(ctx, "media/ab/abcdef.png")
+	if err != nil {
+		t.Errorf("Delete() of a missing blob error = %v", err)
+	}
+
+	err = store.Put(ctx, "../escape.png", data, "image/png")
+	if !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Put() with a bad key error = %v, want ErrInvalidKey", err)
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewLocalStore(filepath.Join(dir, "blobs"))
+	if err != nil {
+		t.Fatalf("NewLocalStore() error = %v", err)
+	}
+	testStore(t, store)
+
+	// no temporary files are left behind
+	entries, err := os.ReadDir(filepath.Join(dir, "blobs", "media", "ab"))
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("store directory has %d leftover entries", len(entries))
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/circuit-shell/http-server-go/internal/auth"
+	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+// LOGIN_FAILED_MESSAGE is the only error a failed login gets, whether the
+// email is unknown or the password is wrong.
+const LOGIN_FAILED_MESSAGE = "Incorrect email or password"
+
+// an account is locked for up to 15 minutes after 5 failures in a row
+var ACCOUNT_LOGIN_BACKOFF = auth.Backoff{FreeAttempts: 5, Base: time.Second, Max: 15 * time.Minute}
+
+// an IP may be shared behind a NAT, so it gets more room before backoff
+var IP_LOGIN_BACKOFF = auth.Backoff{FreeAttempts: 20, Base: time.Second, Max: time.Hour}
+
+func accountLoginKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipLoginKey(r *http.Request) string {
+	return "ip:" + clientIP(r)
+}
+
+// clientIP is the address the request came from, without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loginRetryAfter returns how long the caller must wait before another
+// login attempt is allowed for any of keys, or 0 if none is locked.
+func (cfg *apiConfig) loginRetryAfter(ctx context.Context, keys ...string) (time.Duration, error) {
+	now := time.Now().UTC()
+	wait := time.Duration(0)
+	for _, key := range keys {
+		attempt, err := cfg.dbQueries.GetLoginAttempt(ctx, key)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if attempt.LockedUntil.Valid && attempt.LockedUntil.Time.After(now) {
+			wait = max(wait, attempt.LockedUntil.Time.Sub(now))
+		}
+	}
+	return wait, nil
+}
+
+// recordLoginFailure counts a failed attempt against key and locks it for
+// as long as backoff says.
+func (cfg *apiConfig) recordLoginFailure(ctx context.Context, key string, backoff auth.Backoff) error {
+	attempt, err := cfg.dbQueries.RecordLoginFailure(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	delay := backoff.Delay(int(attempt.Failures))
+	if delay == 0 {
+		return nil
+	}
+	return cfg.dbQueries.LockLogin(ctx, database.LockLoginParams{
+		Key:         key,
+		LockedUntil: sql.NullTime{Time: attempt.LastFailureAt.Add(delay), Valid: true},
+	})
+}
+
+// recordFailedLogin counts a failed attempt against both the account and
+// the caller's IP.
+func (cfg *apiConfig) recordFailedLogin(ctx context.Context, accountKey, ipKey string) error {
+	// the failure must be counted even if the client hangs up
+	ctx = context.WithoutCancel(ctx)
+	err := cfg.recordLoginFailure(ctx, accountKey, ACCOUNT_LOGIN_BACKOFF)
+	if err != nil {
+		return err
+	}
+	return cfg.recordLoginFailure(ctx, ipKey, IP_LOGIN_BACKOFF)
+}
+
+// rejectLogin records a failed attempt against every key and answers with
+// the generic login error.
+func (cfg *apiConfig) rejectLogin(w http.ResponseWriter, r *http.Request, accountKey, ipKey string, reason error) {
+	err := cfg.recordFailedLogin(r.Context(), accountKey, ipKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record login attempt", err)
+		return
+	}
+	respondWithError(w, http.StatusUnauthorized, LOGIN_FAILED_MESSAGE, reason)
+}
+
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+func respondTooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	setRetryAfter(w, wait)
+	respondWithError(w, http.StatusTooManyRequests, "Too many failed login attempts, try again later", nil)
+}
+
+func (cfg *apiConfig) handlerAdminUnlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	err = cfg.dbQueries.ClearLoginAttempts(r.Context(), accountLoginKey(user.Email))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unlock user", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"net/http"
 	"os"
 
+	"github.com/circuit-shell/http-server-go/internal/auth"
 	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/circuit-shell/http-server-go/internal/mailer"
 	"github.com/joho/godotenv"
 
 	_ "github.com/lib/pq"
@@ -26,9 +29,66 @@ func main() {
 		log.Fatal(err)
 	}
 
+	apiCfg.db = db
 	apiCfg.dbQueries = database.New(db)
 	apiCfg.platform = os.Getenv("PLATFORM")
-	apiCfg.serverSecret = os.Getenv("SERVER_SECRET")
+	if adminAPIKey := os.Getenv("ADMIN_API_KEY"); adminAPIKey != "" {
+		apiCfg.adminAPIKeyHash = auth.HashToken(adminAPIKey)
+	}
+
+	keyring, err := loadKeyring(os.Getenv("JWT_KEYS_DIR"), os.Getenv("JWT_ACTIVE_KEY_ID"), os.Getenv("SERVER_SECRET"))
+	if err != nil {
+		log.Fatal("Error loading JWT keys: ", err)
+	}
+	apiCfg.keyring = keyring
+
+	passwords, err := loadPasswordPolicy(os.Getenv("ARGON2_MEMORY_KIB"), os.Getenv("ARGON2_ITERATIONS"), os.Getenv("ARGON2_PARALLELISM"))
+	if err != nil {
+		log.Fatal("Error configuring password hashing: ", err)
+	}
+	apiCfg.passwords = passwords
+
+	apiCfg.appBaseURL = os.Getenv("APP_BASE_URL")
+	if apiCfg.appBaseURL == "" {
+		apiCfg.appBaseURL = "http://localhost:8080"
+	}
+
+	oidcProviders, err := loadOIDCProviders(os.Getenv("OIDC_PROVIDERS"), apiCfg.appBaseURL, os.Getenv)
+	if err != nil {
+		log.Fatal("Error configuring OIDC providers: ", err)
+	}
+	apiCfg.oidcProviders = oidcProviders
+
+	blobs, err := loadBlobStore(os.Getenv)
+	if err != nil {
+		log.Fatal("Error configuring media storage: ", err)
+	}
+	apiCfg.blobs = blobs
+	apiCfg.mediaQueued = make(chan struct{}, 1)
+	apiCfg.startMediaWorkers(context.Background())
+	apiCfg.fanOutQueued = make(chan struct{}, 1)
+	apiCfg.startFanOutWorkers(context.Background())
+
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		apiCfg.mailer = mailer.NewSMTPMailer(
+			os.Getenv("SMTP_HOST"),
+			os.Getenv("SMTP_PORT"),
+			os.Getenv("SMTP_USERNAME"),
+			os.Getenv("SMTP_PASSWORD"),
+			os.Getenv("MAIL_FROM"),
+		)
+	default:
+		apiCfg.mailer = mailer.NewLogMailer(os.Stdout)
+	}
+
+	apiCfg.verifiedEmailRequired = os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true"
+
+	editWindows, err := loadEditWindows(os.Getenv("CHIRP_EDIT_WINDOW"), os.Getenv("CHIRP_EDIT_WINDOW_RED"))
+	if err != nil {
+		log.Fatal("Error configuring chirp editing: ", err)
+	}
+	apiCfg.editWindows = editWindows
 
 	log.Printf("Connected to database: %s", dbURL)
 	log.Printf("Server secret: %s", os.Getenv("SERVER_SECRET"))
@@ -41,21 +101,69 @@ func main() {
 	mux.Handle("/app/", apiCfg.middlewareMetricsInc(http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))))
 
 	mux.HandleFunc("GET /api/healthz", handlerReadiness)
+	mux.HandleFunc("GET /media/{path...}", apiCfg.handlerServeMedia)
+	mux.HandleFunc("GET /.well-known/jwks.json", apiCfg.handlerJWKS)
+	mux.HandleFunc("GET /.well-known/oauth-authorization-server", apiCfg.handlerOAuthMetadata)
 
-	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerMetrics)
-	mux.HandleFunc("POST /admin/reset", apiCfg.handlerMetricsReset)
+	mux.HandleFunc("GET /admin/metrics", apiCfg.requireAdmin(apiCfg.handlerMetrics))
+	mux.HandleFunc("POST /admin/reset", apiCfg.requireAdmin(apiCfg.handlerMetricsReset))
+	mux.HandleFunc("GET /admin/audit", apiCfg.requireAdmin(apiCfg.handlerAdminAudit))
+	mux.HandleFunc("PUT /admin/users/{userID}/role", apiCfg.requireAdmin(apiCfg.handlerAdminUpdateRole))
+	mux.HandleFunc("POST /admin/users/{userID}/unlock", apiCfg.requireAdmin(apiCfg.handlerAdminUnlockUser))
 
 	mux.HandleFunc("POST /api/login", apiCfg.handleLogin)
+	mux.HandleFunc("POST /api/login/mfa", apiCfg.handleLoginMFA)
+	mux.HandleFunc("GET /api/oidc/{provider}/login", apiCfg.handlerOIDCLogin)
+	mux.HandleFunc("GET /api/oidc/{provider}/callback", apiCfg.handlerOIDCCallback)
 	mux.HandleFunc("POST /api/refresh", apiCfg.handleRefresh)
 	mux.HandleFunc("POST /api/revoke", apiCfg.handleRevoke)
-
-	mux.HandleFunc("POST /api/chirps", apiCfg.handlerCreateChirp)
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerChirpsDelete)
-	mux.HandleFunc("GET /api/chirps", apiCfg.handlerReadChirps)
-	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handlerReadChirpById)
+	mux.HandleFunc("POST /api/password/forgot", apiCfg.handlerPasswordForgot)
+	mux.HandleFunc("POST /api/password/reset", apiCfg.handlerPasswordReset)
+
+	mux.HandleFunc("GET /oauth/authorize", apiCfg.handlerOAuthAuthorize)
+	mux.HandleFunc("POST /oauth/authorize", apiCfg.handlerOAuthConsent)
+	mux.HandleFunc("POST /oauth/token", apiCfg.handlerOAuthToken)
+	mux.HandleFunc("POST /oauth/revoke", apiCfg.handlerOAuthRevoke)
+	mux.HandleFunc("POST /oauth/introspect", apiCfg.handlerOAuthIntrospect)
+
+	mux.HandleFunc("POST /api/chirps", apiCfg.requireAuth(auth.ScopeChirpsWrite)(apiCfg.requireVerifiedEmail(apiCfg.handlerCreateChirp)))
+	mux.HandleFunc("PUT /api/chirps/{chirpID}", apiCfg.requireAuth(auth.ScopeChirpsWrite)(apiCfg.requireVerifiedEmail(apiCfg.handlerUpdateChirp)))
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.requireAuth(auth.ScopeChirpsWrite)(apiCfg.handlerChirpsDelete))
+	mux.HandleFunc("POST /api/media", apiCfg.requireAuth(auth.ScopeChirpsWrite)(apiCfg.requireVerifiedEmail(apiCfg.handlerUploadMedia)))
+	mux.HandleFunc("GET /api/media/{mediaID}", apiCfg.requireAuth(auth.ScopeChirpsWrite)(apiCfg.handlerGetMedia))
+	mux.HandleFunc("POST /api/chirps/{chirpID}/like", apiCfg.requireAuth(auth.ScopeChirpsWrite)(apiCfg.handlerLikeChirp))
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/like", apiCfg.requireAuth(auth.ScopeChirpsWrite)(apiCfg.handlerUnlikeChirp))
+	mux.HandleFunc("GET /api/chirps", apiCfg.optionalAuth(apiCfg.handlerReadChirps))
+	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.optionalAuth(apiCfg.handlerReadChirpById))
+	mux.HandleFunc("GET /api/chirps/{chirpID}/revisions", apiCfg.optionalAuth(apiCfg.handlerChirpRevisions))
+	mux.HandleFunc("GET /api/chirps/{chirpID}/thread", apiCfg.optionalAuth(apiCfg.handlerChirpThread))
+	mux.HandleFunc("GET /api/timeline", apiCfg.requireAuth()(apiCfg.handlerTimeline))
 
 	mux.HandleFunc("POST /api/users", apiCfg.handlerCreateUser)
-	mux.HandleFunc("PUT /api/users", apiCfg.handlerUpdateUser)
+	mux.HandleFunc("PUT /api/users", apiCfg.requireAuth(auth.ScopeUsersWrite)(apiCfg.handlerUpdateUser))
+	mux.HandleFunc("PATCH /api/users/me", apiCfg.requireAuth(auth.ScopeUsersWrite)(apiCfg.handlerPatchUser))
+	mux.HandleFunc("POST /api/users/me/avatar", apiCfg.requireAuth(auth.ScopeUsersWrite)(apiCfg.handlerUploadAvatar))
+	mux.HandleFunc("POST /api/users/verify", apiCfg.handlerVerifyEmail)
+	mux.HandleFunc("POST /api/users/verify/resend", apiCfg.requireAuth(auth.ScopeUsersWrite)(apiCfg.handlerResendVerification))
+	mux.HandleFunc("GET /api/users/{handle}", apiCfg.handlerGetProfile)
+	mux.HandleFunc("GET /api/users/{userID}/likes", apiCfg.optionalAuth(apiCfg.handlerUserLikes))
+	mux.HandleFunc("POST /api/users/{userID}/follow", apiCfg.requireAuth(auth.ScopeUsersWrite)(apiCfg.handlerFollowUser))
+	mux.HandleFunc("DELETE /api/users/{userID}/follow", apiCfg.requireAuth(auth.ScopeUsersWrite)(apiCfg.handlerUnfollowUser))
+	mux.HandleFunc("GET /api/users/{userID}/followers", apiCfg.handlerFollowers)
+	mux.HandleFunc("GET /api/users/{userID}/following", apiCfg.handlerFollowing)
+	mux.HandleFunc("GET /api/sessions", apiCfg.requireAuth(auth.ScopeUsersWrite)(apiCfg.handlerListSessions))
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", apiCfg.requireAuth(auth.ScopeUsersWrite)(apiCfg.handlerRevokeSession))
+	mux.HandleFunc("POST /api/sessions/revoke-others", apiCfg.requireAuth(auth.ScopeUsersWrite)(apiCfg.handlerRevokeOtherSessions))
+	mux.HandleFunc("POST /api/keys", apiCfg.requireAuth(auth.ScopeUsersWrite)(apiCfg.handlerCreateAPIKey))
+	mux.HandleFunc("GET /api/keys", apiCfg.requireAuth(auth.ScopeUsersWrite)(apiCfg.handlerListAPIKeys))
+	mux.HandleFunc("DELETE /api/keys/{keyID}", apiCfg.requireAuth(auth.ScopeUsersWrite)(apiCfg.handlerRevokeAPIKey))
+	mux.HandleFunc("POST /api/oauth/clients", apiCfg.requireAuth(auth.ScopeUsersWrite)(apiCfg.handlerCreateOAuthClient))
+	mux.HandleFunc("GET /api/oauth/clients", apiCfg.requireAuth(auth.ScopeUsersWrite)(apiCfg.handlerListOAuthClients))
+	mux.HandleFunc("DELETE /api/oauth/clients/{clientID}", apiCfg.requireAuth(auth.ScopeUsersWrite)(apiCfg.handlerDeleteOAuthClient))
+	mux.HandleFunc("POST /api/mfa/totp", apiCfg.requireAuth(auth.ScopeUsersWrite)(apiCfg.handlerEnrollTOTP))
+	mux.HandleFunc("GET /api/mfa/totp/qr.png", apiCfg.requireAuth(auth.ScopeUsersWrite)(apiCfg.handlerTOTPQRCode))
+	mux.HandleFunc("POST /api/mfa/totp/confirm", apiCfg.requireAuth(auth.ScopeUsersWrite)(apiCfg.handlerConfirmTOTP))
+	mux.HandleFunc("DELETE /api/mfa/totp", apiCfg.requireAuth(auth.ScopeUsersWrite)(apiCfg.handlerDisableTOTP))
 	// mux.HandleFunc("GET /api/users", apiCfg.handlerReadUsers)
 	// mux.HandleFunc("GET /api/users/{id}", apiCfg.handlerReadUser)
 
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/circuit-shell/http-server-go/internal/imaging"
+)
+
+const MEDIA_WORKERS = 2
+
+// workers wake up as soon as something is uploaded, and look for work
+// left behind by a crashed worker every so often
+const MEDIA_POLL_INTERVAL = 30 * time.Second
+
+// startMediaWorkers processes uploaded media in the background until ctx
+// is done.
+func (cfg *apiConfig) startMediaWorkers(ctx context.Context) {
+	for i := 0; i < MEDIA_WORKERS; i++ {
+		go cfg.runMediaWorker(ctx)
+	}
+}
+
+func (cfg *apiConfig) runMediaWorker(ctx context.Context) {
+	for {
+		media, err := cfg.dbQueries.ClaimMedia(ctx)
+		if err == nil {
+			err = cfg.processMedia(ctx, media)
+			if err != nil {
+				log.Printf("Error processing media %s: %s", media.ID, err)
+			}
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error claiming media: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-cfg.mediaQueued:
+		case <-time.After(MEDIA_POLL_INTERVAL):
+		}
+	}
+}
+
+// notifyMediaQueued wakes up an idle worker, if there is one.
+func (cfg *apiConfig) notifyMediaQueued() {
+	select {
+	case cfg.mediaQueued <- struct{}{}:
+	default:
+	}
+}
+
+// processMedia turns an upload into its renditions. Media that isn't a
+// usable image is marked failed; any other error leaves it claimed, so it
+// is retried once the claim runs out.
+func (cfg *apiConfig) processMedia(ctx context.Context, media database.Medium) error {
+	blob, err := cfg.blobs.Get(ctx, media.BlobKey)
+	if err != nil {
+		return fmt.Errorf("reading upload: %w", err)
+	}
+	data, err := io.ReadAll(io.LimitReader(blob.Body, MAX_MEDIA_SIZE+1))
+	blob.Body.Close()
+	if err != nil {
+		return fmt.Errorf("reading upload: %w", err)
+	}
+
+	result, err := imaging.Process(data, imaging.DefaultSizes)
+	if err != nil {
+		log.Printf("Rejecting media %s: %s", media.ID, err)
+		err = cfg.dbQueries.FailMedia(ctx, database.FailMediaParams{
+			ID:    media.ID,
+			Error: mediaFailureReason(err),
+		})
+		if err != nil {
+			return err
+		}
+		cfg.deleteMediaBlobs(ctx, []string{media.BlobKey})
+		return nil
+	}
+
+	avatarKey := ""
+	for _, rendition := range result.Renditions {
+		sum := sha256.Sum256(rendition.Data)
+		digest := hex.EncodeToString(sum[:])
+		key := "media/" + digest[:2] + "/" + digest + ".jpg"
+		err = cfg.blobs.Put(ctx, key, rendition.Data, "image/jpeg")
+		if err != nil {
+			return fmt.Errorf("storing %s rendition: %w", rendition.Name, err)
+		}
+		err = cfg.dbQueries.CreateMediaRendition(ctx, database.CreateMediaRenditionParams{
+			MediaID:     media.ID,
+			Name:        rendition.Name,
+			BlobKey:     key,
+			ContentType: "image/jpeg",
+			Width:       int32(rendition.Width),
+			Height:      int32(rendition.Height),
+			SizeBytes:   int64(len(rendition.Data)),
+		})
+		if err != nil {
+			return err
+		}
+		if rendition.Name == AVATAR_RENDITION {
+			avatarKey = key
+		}
+	}
+
+	// media from before processing was served from its original, which
+	// may be someone's avatar
+	if strings.HasPrefix(media.BlobKey, "media/") && avatarKey != "" {
+		err = cfg.dbQueries.ReplaceAvatarURL(ctx, database.ReplaceAvatarURLParams{
+			OldUrl: cfg.mediaURL(media.BlobKey),
+			NewUrl: cfg.mediaURL(avatarKey),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = cfg.dbQueries.FinishMedia(ctx, database.FinishMediaParams{
+		ID:     media.ID,
+		Width:  int32(result.Width),
+		Height: int32(result.Height),
+	})
+	if err != nil {
+		return err
+	}
+	// the original still has its metadata
+	cfg.deleteMediaBlobs(ctx, []string{media.BlobKey})
+	return nil
+}
+
+func mediaFailureReason(err error) string {
+	switch {
+	case errors.Is(err, imaging.ErrTooLarge):
+		return "Image dimensions are too large"
+	case errors.Is(err, imaging.ErrUnsupported):
+		return "Unsupported image format"
+	default:
+		return "Image is corrupt"
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/circuit-shell/http-server-go/internal/auth"
+	"github.com/circuit-shell/http-server-go/internal/database"
+)
+
+// requireAuth rejects requests without a valid access token or API key
+// carrying every one of scopes, and otherwise puts the caller's
+// auth.Principal into the request context.
+func (cfg *apiConfig) requireAuth(scopes ...string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			principal, msg, err := cfg.authenticate(r)
+			if err != nil {
+				respondWithError(w, http.StatusUnauthorized, msg, err)
+				return
+			}
+
+			for _, scope := range scopes {
+				if !principal.HasScope(scope) {
+					respondWithError(w, http.StatusForbidden, "Missing required scope: "+scope, nil)
+					return
+				}
+			}
+
+			next(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+		}
+	}
+}
+
+// optionalAuth is for endpoints anyone may call that answer differently
+// for a signed-in caller. Requests without credentials go through
+// anonymously; bad credentials are still rejected.
+func (cfg *apiConfig) optionalAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next(w, r)
+			return
+		}
+
+		principal, msg, err := cfg.authenticate(r)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, msg, err)
+			return
+		}
+		next(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	}
+}
+
+// authenticate checks the access token or API key of a request. On error
+// it also returns the message to respond with.
+func (cfg *apiConfig) authenticate(r *http.Request) (auth.Principal, string, error) {
+	if strings.HasPrefix(r.Header.Get("Authorization"), "ApiKey ") {
+		key, err := auth.GetAPIKey(r.Header)
+		if err != nil {
+			return auth.Principal{}, "Couldn't find API key", err
+		}
+		principal, err := cfg.principalFromAPIKey(r.Context(), key)
+		if err != nil {
+			return auth.Principal{}, "Couldn't validate API key", err
+		}
+		return principal, "", nil
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return auth.Principal{}, "Couldn't find JWT", err
+	}
+	principal, err := cfg.keyring.ValidateJWT(token)
+	if err != nil {
+		return auth.Principal{}, "Couldn't validate JWT", err
+	}
+	return principal, "", nil
+}
+
+// requireVerifiedEmail rejects callers whose email address hasn't been
+// confirmed yet, when REQUIRE_VERIFIED_EMAIL is on. It must run after
+// requireAuth.
+func (cfg *apiConfig) requireVerifiedEmail(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.verifiedEmailRequired {
+			next(w, r)
+			return
+		}
+
+		user, err := cfg.dbQueries.GetUserByID(r.Context(), principalFromRequest(r).UserID)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't get user", err)
+			return
+		}
+		if !user.EmailVerifiedAt.Valid {
+			respondWithError(w, http.StatusForbidden, "Verify your email address first", nil)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// principalFromAPIKey looks up an unrevoked, unexpired key and records
+// that it was used.
+func (cfg *apiConfig) principalFromAPIKey(ctx context.Context, key string) (auth.Principal, error) {
+	apiKey, err := cfg.dbQueries.GetActiveAPIKey(ctx, auth.HashToken(key))
+	if err != nil {
+		return auth.Principal{}, err
+	}
+
+	// the owner's role may have changed since the key was created
+	user, err := cfg.dbQueries.GetUserByID(ctx, apiKey.UserID)
+	if err != nil {
+		return auth.Principal{}, err
+	}
+
+	err = cfg.dbQueries.TouchAPIKey(ctx, apiKey.ID)
+	if err != nil {
+		log.Printf("Error recording use of API key %s: %s", apiKey.ID, err)
+	}
+
+	return auth.NewAPIKeyPrincipal(user.ID, auth.Role(user.Role), apiKey.Scopes), nil
+}
+
+// principalFromRequest returns the caller set by requireAuth.
+func principalFromRequest(r *http.Request) auth.Principal {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	return principal
+}
+
+func principalForUser(user database.User) auth.Principal {
+	return auth.NewPrincipal(user.ID, auth.Role(user.Role))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const DEFAULT_PAGE_SIZE = 50
+const MAX_PAGE_SIZE = 100
+
+// pageCursor marks the last row of a page. Cursors are keyed on
+// created_at plus id so rows sharing a timestamp are never skipped.
+type pageCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+func encodeCursor(createdAt time.Time, id uuid.UUID) string {
+	raw := createdAt.Format(time.RFC3339Nano) + "|" + id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return pageCursor{}, errors.New("malformed cursor")
+	}
+
+	createdAtStr, idStr, found := strings.Cut(string(raw), "|")
+	if !found {
+		return pageCursor{}, errors.New("malformed cursor")
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, createdAtStr)
+	if err != nil {
+		return pageCursor{}, errors.New("malformed cursor")
+	}
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return pageCursor{}, errors.New("malformed cursor")
+	}
+
+	return pageCursor{CreatedAt: createdAt, ID: id}, nil
+}
+
+var errCursorMismatch = errors.New("cursor is from a listing with another sort or author_id")
+
+// encodeListCursor is encodeCursor for a listing that can be sorted or
+// filtered; listing names the sort and filters, so the cursor can only
+// continue the listing it came from.
+func encodeListCursor(createdAt time.Time, id uuid.UUID, listing string) string {
+	return encodeCursor(createdAt, id) + "." + base64.RawURLEncoding.EncodeToString([]byte(listing))
+}
+
+func decodeListCursor(cursor, listing string) (pageCursor, error) {
+	position, encodedListing, found := strings.Cut(cursor, ".")
+	if !found {
+		return pageCursor{}, errors.New("malformed cursor")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encodedListing)
+	if err != nil {
+		return pageCursor{}, errors.New("malformed cursor")
+	}
+	if string(raw) != listing {
+		return pageCursor{}, errCursorMismatch
+	}
+	return decodeCursor(position)
+}
+
+// parsePageSize reads the ?limit= query value, falling back to
+// DEFAULT_PAGE_SIZE when it is empty.
+func parsePageSize(limit string) (int, error) {
+	if limit == "" {
+		return DEFAULT_PAGE_SIZE, nil
+	}
+
+	size, err := strconv.Atoi(limit)
+	if err != nil || size < 1 || size > MAX_PAGE_SIZE {
+		return 0, errors.New("limit must be between 1 and " + strconv.Itoa(MAX_PAGE_SIZE))
+	}
+	return size, nil
+}
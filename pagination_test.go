@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestListCursor(t *testing.T) {
+	createdAt := time.Date(2024, 3, 1, 12, 30, 0, 123456789, time.UTC)
+	id := uuid.New()
+	authorID := uuid.New().String()
+	cursor := encodeListCursor(createdAt, id, "desc|"+authorID)
+
+	tests := []struct {
+		name         string
+		cursor       string
+		listing      string
+		wantErr      bool
+		wantMismatch bool
+	}{
+		{name: "same listing", cursor: cursor, listing: "desc|" + authorID},
+		{name: "other sort", cursor: cursor, listing: "asc|" + authorID, wantErr: true, wantMismatch: true},
+		{name: "other author", cursor: cursor, listing: "desc|" + uuid.New().String(), wantErr: true, wantMismatch: true},
+		{name: "no author", cursor: cursor, listing: "desc|", wantErr: true, wantMismatch: true},
+		{name: "plain cursor", cursor: encodeCursor(createdAt, id), listing: "desc|" + authorID, wantErr: true},
+		{name: "garbage", cursor: "not a cursor", listing: "desc|" + authorID, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeListCursor(tt.cursor, tt.listing)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeListCursor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if errors.Is(err, errCursorMismatch) != tt.wantMismatch {
+				t.Errorf("decodeListCursor() error = %v, wantMismatch %v", err, tt.wantMismatch)
+			}
+			if !tt.wantErr && (!got.CreatedAt.Equal(createdAt) || got.ID != id) {
+				t.Errorf("decodeListCursor() = %+v, want %v and %v", got, createdAt, id)
+			}
+		})
+	}
+}
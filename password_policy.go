@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/circuit-shell/http-server-go/internal/auth"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// loadPasswordPolicy hashes new passwords with argon2id, tuned by the
+// ARGON2_* settings when they are set, and keeps verifying the bcrypt
+// hashes stored before the switch. Either kind of outdated hash is
+// replaced on the user's next login.
+func loadPasswordPolicy(memoryKiB, iterations, parallelism string) (*auth.PasswordPolicy, error) {
+	hasher := auth.DefaultArgon2id
+
+	if memoryKiB != "" {
+		value, err := strconv.ParseUint(memoryKiB, 10, 32)
+		if err != nil || value < 8 {
+			return nil, fmt.Errorf("ARGON2_MEMORY_KIB must be a number of KiB, at least 8: %q", memoryKiB)
+		}
+		hasher.Memory = uint32(value)
+	}
+	if iterations != "" {
+		value, err := strconv.ParseUint(iterations, 10, 32)
+		if err != nil || value < 1 {
+			return nil, fmt.Errorf("ARGON2_ITERATIONS must be a positive number: %q", iterations)
+		}
+		hasher.Iterations = uint32(value)
+	}
+	if parallelism != "" {
+		value, err := strconv.ParseUint(parallelism, 10, 8)
+		if err != nil || value < 1 {
+			return nil, fmt.Errorf("ARGON2_PARALLELISM must be between 1 and 255: %q", parallelism)
+		}
+		hasher.Parallelism = uint8(value)
+	}
+
+	return auth.NewPasswordPolicy(hasher, auth.BcryptHasher{Cost: bcrypt.DefaultCost}), nil
+}
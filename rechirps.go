@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/circuit-shell/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+var errInvalidReference = errors.New("invalid chirp reference")
+
+// referencedChirp looks up a chirp being replied to, rechirped or quoted.
+// A rechirp stands in for the chirp it shares.
+func (cfg *apiConfig) referencedChirp(ctx context.Context, chirpID uuid.UUID) (database.Chirp, error) {
+	chirp, err := cfg.dbQueries.GetChirpsByID(ctx, chirpID)
+	if err == nil && chirp.RechirpOf.Valid {
+		chirp, err = cfg.dbQueries.GetChirpsByID(ctx, chirp.RechirpOf.UUID)
+	}
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && chirp.DeletedAt.Valid) {
+		return database.Chirp{}, fmt.Errorf("%w: couldn't find chirp %s", errInvalidReference, chirpID)
+	}
+	return chirp, err
+}
+
+// embedOriginals fills in the chirps that chirps rechirp or quote, one
+// level deep. A quoted chirp that has been deleted is embedded as a
+// tombstone, so the quote can show it as unavailable; rechirps of it are
+// deleted along with it.
+func (cfg *apiConfig) embedOriginals(ctx context.Context, viewerID uuid.UUID, withAuthors bool, chirps []Chirp) error {
+	originalIDs := []uuid.UUID{}
+	for _, chirp := range chirps {
+		if chirp.RechirpOf != nil {
+			originalIDs = append(originalIDs, *chirp.RechirpOf)
+		}
+		if chirp.QuoteOf != nil {
+			originalIDs = append(originalIDs, *chirp.QuoteOf)
+		}
+	}
+	if len(originalIDs) == 0 {
+		return nil
+	}
+
+	dbOriginals, err := cfg.dbQueries.GetChirpsByIDs(ctx, originalIDs)
+	if err != nil {
+		return err
+	}
+	originals := make([]Chirp, len(dbOriginals))
+	for i, original := range dbOriginals {
+		originals[i] = chirpFromDatabase(original)
+	}
+	err = cfg.embedMedia(ctx, originals)
+	if err != nil {
+		return err
+	}
+	err = cfg.embedLikes(ctx, viewerID, originals)
+	if err != nil {
+		return err
+	}
+	if withAuthors {
+		err = cfg.embedAuthors(ctx, originals)
+		if err != nil {
+			return err
+		}
+	}
+
+	byID := map[uuid.UUID]*Chirp{}
+	for i := range originals {
+		byID[originals[i].ID] = &originals[i]
+	}
+	for i := range chirps {
+		if chirps[i].RechirpOf != nil {
+			chirps[i].Rechirped = byID[*chirps[i].RechirpOf]
+		}
+		if chirps[i].QuoteOf != nil {
+			chirps[i].Quoted = byID[*chirps[i].QuoteOf]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+-- name: CreateAdminAuditEntry :exec
+INSERT INTO admin_audit_log (id, created_at, actor_type, actor_user_id, action, status_code, remote_addr)
+VALUES (gen_random_uuid(), now(), $1, $2, $3, $4, $5);
+
+-- name: GetAdminAuditEntries :many
+SELECT * FROM admin_audit_log
+ORDER BY created_at DESC
+LIMIT $1;
@@ -0,0 +1,24 @@
+-- name: CreateAPIKey :one
+INSERT INTO api_keys (id, user_id, name, key_hash, key_hint, scopes, created_at, expires_at, last_used_at, revoked_at)
+VALUES (gen_random_uuid(), $1, $2, $3, $4, $5, NOW(), $6, NULL, NULL)
+RETURNING *;
+
+-- name: GetAPIKeysByUser :many
+SELECT * FROM api_keys
+WHERE user_id = $1 AND revoked_at IS NULL
+ORDER BY created_at DESC;
+
+-- name: GetActiveAPIKey :one
+SELECT * FROM api_keys
+WHERE key_hash = $1 AND revoked_at IS NULL AND expires_at > NOW();
+
+-- name: TouchAPIKey :exec
+-- recorded at most once a minute, so busy bots don't write on every request
+UPDATE api_keys
+  SET last_used_at = NOW()
+  WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute');
+
+-- name: RevokeAPIKey :execrows
+UPDATE api_keys
+  SET revoked_at = NOW()
+  WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;
@@ -0,0 +1,39 @@
+-- name: LikeChirp :exec
+-- Liking twice does nothing, and only a like that was actually added is
+-- counted. Concurrent likes queue up on the chirp's row lock.
+WITH liked AS (
+  INSERT INTO chirp_likes (user_id, chirp_id, created_at)
+  VALUES ($1, $2, NOW())
+  ON CONFLICT (user_id, chirp_id) DO NOTHING
+  RETURNING chirp_id
+)
+UPDATE chirps
+  SET like_count = like_count + 1
+  WHERE id IN (SELECT chirp_id FROM liked);
+
+-- name: UnlikeChirp :exec
+WITH unliked AS (
+  DELETE FROM chirp_likes
+  WHERE chirp_likes.user_id = $1 AND chirp_likes.chirp_id = $2
+  RETURNING chirp_id
+)
+UPDATE chirps
+  SET like_count = like_count - 1
+  WHERE id IN (SELECT chirp_id FROM unliked);
+
+-- name: GetLikedChirpIDs :many
+-- Which of chirp_ids user_id has liked.
+SELECT chirp_id FROM chirp_likes
+WHERE user_id = $1 AND chirp_id = ANY(sqlc.arg(chirp_ids)::uuid[]);
+
+-- name: GetLikedChirpsPage :many
+-- The chirps user_id has liked, most recently liked first.
+SELECT sqlc.embed(chirps), chirp_likes.created_at AS liked_at
+FROM chirp_likes
+JOIN chirps ON chirps.id = chirp_likes.chirp_id
+WHERE chirp_likes.user_id = sqlc.arg('user_id')
+  AND chirps.deleted_at IS NULL
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (chirp_likes.created_at, chirp_likes.chirp_id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY chirp_likes.created_at DESC, chirp_likes.chirp_id DESC
+LIMIT sqlc.arg('page_size');
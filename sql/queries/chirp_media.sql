@@ -0,0 +1,14 @@
+-- name: AttachChirpMedia :exec
+INSERT INTO chirp_media (chirp_id, media_id, position, alt_text)
+VALUES ($1, $2, $3, $4);
+
+-- name: DetachChirpMedia :exec
+DELETE FROM chirp_media
+WHERE chirp_id = $1;
+
+-- name: GetChirpMedia :many
+SELECT chirp_media.chirp_id, chirp_media.alt_text, sqlc.embed(media)
+FROM chirp_media
+JOIN media ON media.id = chirp_media.media_id
+WHERE chirp_media.chirp_id = ANY(sqlc.arg(chirp_ids)::uuid[])
+ORDER BY chirp_media.chirp_id, chirp_media.position;
@@ -1,16 +1,100 @@
 -- name: CreateChirp :one
-INSERT INTO chirps(id, created_at, updated_at, body, user_id)
-VALUES ( gen_random_uuid(), now(),now(),$1,$2)
+INSERT INTO chirps(id, created_at, updated_at, body, user_id, in_reply_to, root_id, rechirp_of, quote_of)
+VALUES ( gen_random_uuid(), now(),now(),$1,$2,$3,$4,$5,$6)
 RETURNING *;
 
--- name: GetChirps :many
+-- name: GetChirpsPageAsc :many
 SELECT * FROM chirps
-ORDER BY created_at ASC;
+WHERE deleted_at IS NULL
+  AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'))
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('page_size');
+
+-- name: GetChirpsPageDesc :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+  AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'))
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_size');
 
 -- name: GetChirpsByID :one
 SELECT * FROM chirps WHERE id = $1;
 
+-- name: GetChirpsByIDs :many
+SELECT * FROM chirps WHERE id = ANY(sqlc.arg(ids)::uuid[]);
+
+-- name: GetChirpForUpdate :one
+SELECT * FROM chirps WHERE id = $1
+FOR UPDATE;
+
+-- name: UpdateChirpBody :one
+UPDATE chirps
+  SET body = $2, updated_at = NOW()
+  WHERE id = $1
+RETURNING *;
+
+-- name: ChirpIsReferenced :one
+-- Whether any chirp replies to or quotes chirp_id.
+SELECT EXISTS (
+  SELECT 1 FROM chirps
+  WHERE in_reply_to = sqlc.arg(chirp_id)::uuid OR quote_of = sqlc.arg(chirp_id)::uuid
+);
+
+-- name: GetChirpAncestors :many
+-- The chirps chirp_id replies to, up to max_ancestors of them, nearest
+-- first.
+WITH RECURSIVE ancestors AS (
+  SELECT child.in_reply_to AS id, 1 AS distance
+  FROM chirps child
+  WHERE child.id = sqlc.arg('chirp_id')
+  UNION ALL
+  SELECT parent.in_reply_to, ancestors.distance + 1
+  FROM ancestors
+  JOIN chirps parent ON parent.id = ancestors.id
+  WHERE ancestors.distance < sqlc.arg('max_ancestors')::int
+)
+SELECT chirps.*
+FROM ancestors
+JOIN chirps ON chirps.id = ancestors.id
+ORDER BY ancestors.distance ASC;
+
+-- name: GetChirpReplies :many
+-- The replies below chirp_id, at most max_depth levels down, oldest first.
+-- A reply is newer than what it replies to, so each page hangs off the
+-- chirps on earlier pages.
+WITH RECURSIVE replies AS (
+  SELECT chirps.id, 1 AS depth
+  FROM chirps
+  WHERE chirps.in_reply_to = sqlc.arg('chirp_id')::uuid
+  UNION ALL
+  SELECT chirps.id, replies.depth + 1
+  FROM replies
+  JOIN chirps ON chirps.in_reply_to = replies.id
+  WHERE replies.depth < sqlc.arg('max_depth')::int
+)
+SELECT chirps.*
+FROM replies
+JOIN chirps ON chirps.id = replies.id
+WHERE sqlc.narg('cursor_created_at')::timestamp IS NULL
+  OR (chirps.created_at, chirps.id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+ORDER BY chirps.created_at ASC, chirps.id ASC
+LIMIT sqlc.arg('page_size');
+
+-- name: TombstoneChirp :exec
+-- Keeps a deleted chirp that has replies, without its body, so the thread
+-- below it stays connected.
+UPDATE chirps
+  SET body = '', deleted_at = NOW(), updated_at = NOW()
+  WHERE id = $1;
+
+-- name: DeleteRechirps :exec
+DELETE FROM chirps
+WHERE rechirp_of = $1;
+
 -- name: DeleteChirp :exec
 DELETE FROM chirps
 WHERE id = $1;
-
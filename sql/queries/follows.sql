@@ -0,0 +1,45 @@
+-- name: LockFollowUsers :many
+-- Locks both sides of a follow in id order, so follows going both ways at
+-- once can't deadlock on the counters. Also holds off fanning out chirps by
+-- either user until the timeline has caught up.
+SELECT id FROM users
+WHERE id = ANY(sqlc.arg(ids)::uuid[])
+ORDER BY id
+FOR NO KEY UPDATE;
+
+-- name: FollowUser :execrows
+INSERT INTO follows (follower_id, followee_id, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (follower_id, followee_id) DO NOTHING;
+
+-- name: UnfollowUser :execrows
+DELETE FROM follows
+WHERE follower_id = $1 AND followee_id = $2;
+
+-- name: AdjustFollowCounts :exec
+UPDATE users
+SET follower_count = follower_count + CASE WHEN id = sqlc.arg(followee_id)::uuid THEN sqlc.arg(delta)::int ELSE 0 END,
+    following_count = following_count + CASE WHEN id = sqlc.arg(follower_id)::uuid THEN sqlc.arg(delta)::int ELSE 0 END
+WHERE id IN (sqlc.arg(follower_id)::uuid, sqlc.arg(followee_id)::uuid);
+
+-- name: GetFollowersPage :many
+-- Who follows user_id, most recent first.
+SELECT users.id, users.handle, users.display_name, users.avatar_url, follows.created_at AS followed_at
+FROM follows
+JOIN users ON users.id = follows.follower_id
+WHERE follows.followee_id = sqlc.arg('user_id')
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (follows.created_at, follows.follower_id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY follows.created_at DESC, follows.follower_id DESC
+LIMIT sqlc.arg('page_size');
+
+-- name: GetFollowingPage :many
+-- Who user_id follows, most recent first.
+SELECT users.id, users.handle, users.display_name, users.avatar_url, follows.created_at AS followed_at
+FROM follows
+JOIN users ON users.id = follows.followee_id
+WHERE follows.follower_id = sqlc.arg('user_id')
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (follows.created_at, follows.followee_id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY follows.created_at DESC, follows.followee_id DESC
+LIMIT sqlc.arg('page_size');
@@ -0,0 +1,22 @@
+-- name: GetLoginAttempt :one
+SELECT * FROM login_attempts WHERE key = $1;
+
+-- name: RecordLoginFailure :one
+-- failures older than a day are forgotten
+INSERT INTO login_attempts (key, failures, last_failure_at, locked_until)
+VALUES ($1, 1, NOW(), NULL)
+ON CONFLICT (key) DO UPDATE
+  SET failures = CASE
+      WHEN login_attempts.last_failure_at < NOW() - INTERVAL '24 hours' THEN 1
+      ELSE login_attempts.failures + 1
+    END,
+    last_failure_at = NOW()
+RETURNING *;
+
+-- name: LockLogin :exec
+UPDATE login_attempts
+  SET locked_until = $2
+  WHERE key = $1;
+
+-- name: ClearLoginAttempts :exec
+DELETE FROM login_attempts WHERE key = $1;
@@ -0,0 +1,77 @@
+-- name: CreateMedia :one
+-- claimed_at is set when the uploader processes the media itself, so the
+-- workers leave it alone
+INSERT INTO media (id, user_id, blob_key, content_type, size_bytes, created_at, claimed_at)
+VALUES (gen_random_uuid(), $1, $2, $3, $4, NOW(), $5)
+RETURNING *;
+
+-- name: GetMedia :one
+SELECT * FROM media
+WHERE id = $1;
+
+-- name: ClaimMedia :one
+-- a claim older than five minutes belongs to a worker that died
+UPDATE media
+  SET claimed_at = NOW()
+  WHERE id = (
+    SELECT id FROM media
+    WHERE status = 'processing' AND (claimed_at IS NULL OR claimed_at < NOW() - INTERVAL '5 minutes')
+    ORDER BY created_at
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+  )
+RETURNING *;
+
+-- name: FinishMedia :exec
+UPDATE media
+  SET status = 'ready', width = $2, height = $3, processed_at = NOW()
+  WHERE id = $1;
+
+-- name: FailMedia :exec
+UPDATE media
+  SET status = 'failed', error = $2, processed_at = NOW()
+  WHERE id = $1;
+
+-- name: CreateMediaRendition :exec
+-- a retried job replaces what a crashed one left behind
+INSERT INTO media_renditions (media_id, name, blob_key, content_type, width, height, size_bytes)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (media_id, name) DO UPDATE
+  SET blob_key = EXCLUDED.blob_key,
+      content_type = EXCLUDED.content_type,
+      width = EXCLUDED.width,
+      height = EXCLUDED.height,
+      size_bytes = EXCLUDED.size_bytes;
+
+-- name: GetMediaRenditions :many
+SELECT * FROM media_renditions
+WHERE media_id = $1
+ORDER BY width;
+
+-- name: GetMediaRenditionsByMediaIDs :many
+SELECT * FROM media_renditions
+WHERE media_id = ANY(sqlc.arg(media_ids)::uuid[])
+ORDER BY media_id, width;
+
+-- name: DeleteUnreferencedMedia :many
+-- of the given media, deletes what no chirp uses any more and isn't
+-- anyone's avatar. An avatar_url is url_prefix followed by a rendition's
+-- blob_key.
+DELETE FROM media
+WHERE id = ANY(sqlc.arg(ids)::uuid[])
+  AND NOT EXISTS (SELECT 1 FROM chirp_media WHERE chirp_media.media_id = media.id)
+  AND NOT EXISTS (
+    SELECT 1 FROM media_renditions
+    JOIN users ON users.avatar_url = sqlc.arg(url_prefix)::text || media_renditions.blob_key
+    WHERE media_renditions.media_id = media.id
+  )
+RETURNING *;
+
+-- name: GetUnreferencedBlobKeys :many
+-- renditions are content-addressed, so different media can share a blob;
+-- avatar_url can point at any of them. Media from before processing
+-- shares its originals too, until it is processed.
+SELECT candidate.key::text AS key FROM unnest(sqlc.arg(blob_keys)::text[]) AS candidate(key)
+WHERE NOT EXISTS (SELECT 1 FROM media_renditions WHERE media_renditions.blob_key = candidate.key)
+  AND NOT EXISTS (SELECT 1 FROM media WHERE media.blob_key = candidate.key AND media.status = 'processing')
+  AND NOT EXISTS (SELECT 1 FROM users WHERE users.avatar_url = sqlc.arg(url_prefix)::text || candidate.key);
@@ -0,0 +1,28 @@
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, owner_user_id, name, secret_hash, redirect_uris, scopes, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, NOW())
+RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT * FROM oauth_clients WHERE id = $1;
+
+-- name: GetOAuthClientsByOwner :many
+SELECT * FROM oauth_clients
+WHERE owner_user_id = $1
+ORDER BY created_at DESC;
+
+-- name: DeleteOAuthClient :execrows
+DELETE FROM oauth_clients WHERE id = $1 AND owner_user_id = $2;
+
+-- name: CreateAuthorizationCode :exec
+INSERT INTO oauth_authorization_codes (code_hash, client_id, user_id, redirect_uri, scope, code_challenge, family_id, created_at, expires_at, used_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), NOW() + INTERVAL '5 minutes', NULL);
+
+-- name: ConsumeAuthorizationCode :one
+UPDATE oauth_authorization_codes
+  SET used_at = NOW()
+  WHERE code_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+  RETURNING *;
+
+-- name: GetAuthorizationCode :one
+SELECT * FROM oauth_authorization_codes WHERE code_hash = $1;
@@ -1,32 +1,79 @@
 -- name: CreateRefreshToken :one
-WITH revoke_tokens AS (
-    UPDATE refresh_tokens
-    SET 
-        revoked_at = NOW(),
-        updated_at = NOW()
-    WHERE user_id = $2 
-        AND revoked_at IS NULL
-)
 INSERT INTO refresh_tokens (
-    token,
+    token_hash,
     user_id,
+    family_id,
     created_at,
     updated_at,
     expires_at,
-    revoked_at
+    revoked_at,
+    user_agent,
+    ip_address,
+    device_name,
+    last_used_at,
+    session_started_at,
+    client_id,
+    scope
 ) VALUES (
     $1,
     $2,
+    $3,
     NOW(),
     NOW(),
     NOW() + INTERVAL '60 days',
-    NULL
+    NULL,
+    $4,
+    $5,
+    $6,
+    NOW(),
+    $7,
+    $8,
+    $9
 ) RETURNING *;
 
 
 -- name: GetUserFromRefreshToken :one
-SELECT * FROM refresh_tokens WHERE token = $1 AND revoked_at IS NULL;
+SELECT * FROM refresh_tokens WHERE token_hash = $1 AND revoked_at IS NULL;
+
+-- name: GetRefreshToken :one
+SELECT * FROM refresh_tokens WHERE token_hash = $1;
+
+-- name: RotateRefreshToken :execrows
+UPDATE refresh_tokens
+  SET revoked_at = NOW(), updated_at = NOW(), replaced_by_hash = $2
+  WHERE token_hash = $1 AND revoked_at IS NULL;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+  SET revoked_at = NOW(), updated_at = NOW()
+  WHERE family_id = $1 AND revoked_at IS NULL;
+
+-- name: GetActiveSessions :many
+-- each family has at most one unrevoked token, the session's current one
+SELECT family_id, user_agent, ip_address, device_name, session_started_at, last_used_at, expires_at
+FROM refresh_tokens
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+ORDER BY last_used_at DESC;
 
+-- name: IsSessionActive :one
+SELECT EXISTS (
+  SELECT 1 FROM refresh_tokens
+  WHERE family_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+);
+
+-- name: GetSessionStartedAt :one
+SELECT session_started_at FROM refresh_tokens
+WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW();
+
+-- name: RevokeSession :execrows
+UPDATE refresh_tokens
+  SET revoked_at = NOW(), updated_at = NOW()
+  WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeOtherSessions :execrows
+UPDATE refresh_tokens
+  SET revoked_at = NOW(), updated_at = NOW()
+  WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL;
 
 -- name: RevokeRefreshTokens :exec
 UPDATE refresh_tokens
@@ -0,0 +1,86 @@
+-- name: QueueFanOut :exec
+INSERT INTO fanout_queue (chirp_id, created_at)
+VALUES ($1, NOW());
+
+-- name: ClaimFanOut :one
+-- a claim older than five minutes belongs to a worker that died
+UPDATE fanout_queue
+  SET claimed_at = NOW()
+  WHERE chirp_id = (
+    SELECT chirp_id FROM fanout_queue
+    WHERE claimed_at IS NULL OR claimed_at < NOW() - INTERVAL '5 minutes'
+    ORDER BY created_at
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+  )
+RETURNING chirp_id;
+
+-- name: FinishFanOut :exec
+DELETE FROM fanout_queue
+WHERE chirp_id = $1;
+
+-- name: GetFollowerCountForShare :one
+-- Holds off follows and unfollows of the author while a chirp is fanned
+-- out, so every follower either gets it fanned out or backfilled.
+SELECT follower_count FROM users
+WHERE id = $1
+FOR SHARE;
+
+-- name: FanOutChirp :exec
+-- Copies a new chirp into the timeline of everyone following its author.
+INSERT INTO timeline_entries (user_id, chirp_id, author_id, created_at)
+SELECT follows.follower_id, sqlc.arg(chirp_id)::uuid, follows.followee_id, sqlc.arg(created_at)::timestamp
+FROM follows
+WHERE follows.followee_id = sqlc.arg(author_id)::uuid;
+
+-- name: MarkChirpFannedOut :exec
+UPDATE chirps
+  SET fanned_out = true
+  WHERE id = $1;
+
+-- name: BackfillTimeline :exec
+-- Copies the latest fanned-out chirps of a newly followed account into the
+-- follower's timeline. Chirps that weren't fanned out need no copying.
+INSERT INTO timeline_entries (user_id, chirp_id, author_id, created_at)
+SELECT sqlc.arg(user_id)::uuid, chirps.id, chirps.user_id, chirps.created_at
+FROM chirps
+WHERE chirps.user_id = sqlc.arg(author_id)::uuid
+  AND chirps.fanned_out
+  AND chirps.deleted_at IS NULL
+ORDER BY chirps.created_at DESC
+LIMIT sqlc.arg(max_entries)::int
+ON CONFLICT (user_id, chirp_id) DO NOTHING;
+
+-- name: RemoveFromTimeline :exec
+DELETE FROM timeline_entries
+WHERE user_id = $1 AND author_id = $2;
+
+-- name: GetTimelinePage :many
+-- Newest first: the reader's timeline entries, plus the chirps of followed
+-- accounts that weren't fanned out. Each side is cut to a page before they
+-- are merged, so neither has to be read in full.
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, root_id, deleted_at, like_count, rechirp_of, quote_of, fanned_out
+FROM (
+  (SELECT chirps.*
+  FROM timeline_entries
+  JOIN chirps ON chirps.id = timeline_entries.chirp_id
+  WHERE timeline_entries.user_id = sqlc.arg('user_id')
+    AND chirps.deleted_at IS NULL
+    AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+      OR (timeline_entries.created_at, timeline_entries.chirp_id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+  ORDER BY timeline_entries.created_at DESC, timeline_entries.chirp_id DESC
+  LIMIT sqlc.arg('page_size'))
+  UNION ALL
+  (SELECT chirps.*
+  FROM follows
+  JOIN chirps ON chirps.user_id = follows.followee_id
+  WHERE follows.follower_id = sqlc.arg('user_id')
+    AND NOT chirps.fanned_out
+    AND chirps.deleted_at IS NULL
+    AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+      OR (chirps.created_at, chirps.id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+  ORDER BY chirps.created_at DESC, chirps.id DESC
+  LIMIT sqlc.arg('page_size'))
+) AS timeline
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_size');
@@ -0,0 +1,39 @@
+-- name: UpsertUserTOTP :one
+-- enrolling again replaces a secret that was never confirmed, but never a
+-- confirmed one
+INSERT INTO user_totp (user_id, secret, confirmed_at, last_used_step, created_at, updated_at)
+VALUES ($1, $2, NULL, 0, NOW(), NOW())
+ON CONFLICT (user_id) DO UPDATE
+  SET secret = EXCLUDED.secret, updated_at = NOW()
+  WHERE user_totp.confirmed_at IS NULL
+RETURNING *;
+
+-- name: GetUserTOTP :one
+SELECT * FROM user_totp WHERE user_id = $1;
+
+-- name: ConfirmUserTOTP :execrows
+UPDATE user_totp
+  SET confirmed_at = NOW(), last_used_step = $2, updated_at = NOW()
+  WHERE user_id = $1 AND confirmed_at IS NULL;
+
+-- name: UseTOTPStep :execrows
+-- a code is accepted at most once: the step must be newer than the last
+-- one used
+UPDATE user_totp
+  SET last_used_step = $2, updated_at = NOW()
+  WHERE user_id = $1 AND last_used_step < $2;
+
+-- name: DeleteUserTOTP :execrows
+DELETE FROM user_totp WHERE user_id = $1;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO mfa_recovery_codes (id, user_id, code_hash, created_at, used_at)
+VALUES (gen_random_uuid(), $1, $2, NOW(), NULL);
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM mfa_recovery_codes WHERE user_id = $1;
+
+-- name: UseRecoveryCode :execrows
+UPDATE mfa_recovery_codes
+  SET used_at = NOW()
+  WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;
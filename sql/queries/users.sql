@@ -1,18 +1,12 @@
 -- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password)
-VALUES ( gen_random_uuid(), now(),now(),$1,$2)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, handle)
+VALUES ( gen_random_uuid(), now(),now(),$1,$2,$3)
 RETURNING *;
 
 -- name: DeleteUsers :exec
 DELETE FROM users;
 
 
--- name: UpdateUser :one
-UPDATE users
-SET email = $2, hashed_password = $3, updated_at = now()
-WHERE id = $1
-RETURNING *;
-
 -- name: GetUsers :many
 SELECT * FROM users;
 
@@ -21,3 +15,71 @@ SELECT * FROM users WHERE id = $1;
 
 -- name: GetUserByEmail :one
 SELECT * FROM users WHERE email = $1;
+
+-- name: GetUserByHandle :one
+SELECT * FROM users WHERE lower(handle) = lower($1);
+
+-- name: GetUserProfilesByIDs :many
+-- the public part of each user, for embedding authors in chirps
+SELECT id, handle, display_name, avatar_url FROM users
+WHERE id = ANY(sqlc.arg(ids)::uuid[]);
+
+-- name: UpgradeToChirpyRed :one
+UPDATE users
+SET is_chirpy_red = true, updated_at = now()
+WHERE id = $1
+RETURNING *;
+
+-- name: UpdateUserRole :one
+UPDATE users
+SET role = $2, updated_at = now()
+WHERE id = $1
+RETURNING *;
+
+-- name: UpdateUserPassword :exec
+UPDATE users
+SET hashed_password = $2, updated_at = now()
+WHERE id = $1;
+
+-- name: RehashUserPassword :exec
+-- only replaces the hash that was checked, so a concurrent password
+-- change wins
+UPDATE users
+SET hashed_password = sqlc.arg(hashed_password)
+WHERE id = sqlc.arg(id) AND hashed_password = sqlc.arg(old_hashed_password);
+
+-- name: SetUserPendingEmail :one
+UPDATE users
+SET pending_email = $2, updated_at = now()
+WHERE id = $1
+RETURNING *;
+
+-- name: VerifyUserEmail :one
+UPDATE users
+SET email = $2, email_verified_at = now(), pending_email = NULL, updated_at = now()
+WHERE id = $1
+RETURNING *;
+
+-- name: CreateOIDCUser :one
+-- the address was verified by the provider; an empty hash matches no
+-- password, so the account can only sign in through its identity
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, email_verified_at, handle)
+VALUES (gen_random_uuid(), now(), now(), $1, '', now(), $2)
+RETURNING *;
+
+-- name: PatchUser :one
+-- a NULL argument leaves its column as it is
+UPDATE users
+SET display_name = COALESCE(sqlc.narg(display_name), display_name),
+    bio = COALESCE(sqlc.narg(bio), bio),
+    handle = COALESCE(sqlc.narg(handle), handle),
+    avatar_url = COALESCE(sqlc.narg(avatar_url), avatar_url),
+    hashed_password = COALESCE(sqlc.narg(hashed_password), hashed_password),
+    updated_at = now()
+WHERE id = sqlc.arg(id)
+RETURNING *;
+
+-- name: ReplaceAvatarURL :exec
+UPDATE users
+SET avatar_url = sqlc.arg(new_url), updated_at = now()
+WHERE avatar_url = sqlc.arg(old_url);
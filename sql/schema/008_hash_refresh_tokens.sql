@@ -0,0 +1,14 @@
+-- +goose Up
+UPDATE refresh_tokens
+SET token = encode(sha256(convert_to(token, 'UTF8')), 'hex'),
+    replaced_by = encode(sha256(convert_to(replaced_by, 'UTF8')), 'hex');
+
+ALTER TABLE refresh_tokens RENAME COLUMN token TO token_hash;
+ALTER TABLE refresh_tokens RENAME COLUMN replaced_by TO replaced_by_hash;
+
+-- +goose Down
+-- digests can't be turned back into tokens, so every session is dropped
+DELETE FROM refresh_tokens;
+
+ALTER TABLE refresh_tokens RENAME COLUMN replaced_by_hash TO replaced_by;
+ALTER TABLE refresh_tokens RENAME COLUMN token_hash TO token;
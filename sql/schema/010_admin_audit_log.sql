@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE admin_audit_log (
+  id UUID PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL,
+  actor_type TEXT NOT NULL CHECK (actor_type IN ('user', 'api_key')),
+  -- no foreign key: entries must outlive the users they mention
+  actor_user_id UUID,
+  action TEXT NOT NULL,
+  status_code INTEGER NOT NULL,
+  remote_addr TEXT NOT NULL
+);
+
+CREATE INDEX admin_audit_log_created_at_idx ON admin_audit_log (created_at);
+
+-- +goose Down
+DROP TABLE admin_audit_log;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- failed logins, keyed by "email:<address>" or "ip:<address>"; the email
+-- is used rather than the user ID so unknown accounts are throttled too
+CREATE TABLE login_attempts(
+  key TEXT PRIMARY KEY,
+  failures INTEGER NOT NULL,
+  last_failure_at TIMESTAMP NOT NULL,
+  locked_until TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE login_attempts;
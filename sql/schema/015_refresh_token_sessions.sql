@@ -0,0 +1,32 @@
+-- +goose Up
+-- a session is a refresh token family; every token of a family carries
+-- the session's details so the active one can be listed on its own
+ALTER TABLE refresh_tokens
+ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+ADD COLUMN ip_address TEXT NOT NULL DEFAULT '',
+ADD COLUMN device_name TEXT,
+ADD COLUMN last_used_at TIMESTAMP,
+ADD COLUMN session_started_at TIMESTAMP;
+
+UPDATE refresh_tokens
+SET last_used_at = created_at,
+    session_started_at = (
+      SELECT MIN(family.created_at) FROM refresh_tokens family
+      WHERE family.family_id = refresh_tokens.family_id
+    );
+
+ALTER TABLE refresh_tokens
+ALTER COLUMN last_used_at SET NOT NULL,
+ALTER COLUMN session_started_at SET NOT NULL;
+
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id) WHERE revoked_at IS NULL;
+
+-- +goose Down
+DROP INDEX refresh_tokens_user_id_idx;
+
+ALTER TABLE refresh_tokens
+DROP COLUMN session_started_at,
+DROP COLUMN last_used_at,
+DROP COLUMN device_name,
+DROP COLUMN ip_address,
+DROP COLUMN user_agent;
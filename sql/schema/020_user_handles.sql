@@ -0,0 +1,21 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN handle TEXT,
+ADD COLUMN avatar_url TEXT NOT NULL DEFAULT '';
+
+-- existing users get a placeholder they can change
+UPDATE users SET handle = 'user_' || substr(replace(id::text, '-', ''), 1, 12);
+
+ALTER TABLE users
+ALTER COLUMN handle SET NOT NULL,
+ADD CONSTRAINT users_handle_format CHECK (handle ~ '^[A-Za-z0-9_]{3,30}$');
+
+-- handles are unique regardless of case, but keep the case they were chosen in
+CREATE UNIQUE INDEX users_handle_lower_idx ON users (lower(handle));
+
+-- +goose Down
+DROP INDEX users_handle_lower_idx;
+
+ALTER TABLE users
+DROP COLUMN avatar_url,
+DROP COLUMN handle;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- blobs are content-addressed, so users uploading the same file share
+-- one blob_key but each get their own row
+CREATE TABLE media(
+  id UUID PRIMARY KEY,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  blob_key TEXT NOT NULL,
+  content_type TEXT NOT NULL,
+  size_bytes BIGINT NOT NULL,
+  created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX media_user_id_idx ON media (user_id);
+CREATE INDEX media_blob_key_idx ON media (blob_key);
+
+-- +goose Down
+DROP TABLE media;
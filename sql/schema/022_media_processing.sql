@@ -0,0 +1,43 @@
+-- +goose Up
+-- uploads are processed in the background; chirps may reference media
+-- that is still processing and show it once it is ready. blob_key now
+-- names the private original, which is deleted once it is processed, and
+-- the renditions are what gets served.
+ALTER TABLE media
+ADD COLUMN status TEXT NOT NULL DEFAULT 'processing' CHECK (status IN ('processing', 'ready', 'failed')),
+ADD COLUMN width INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN height INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN error TEXT NOT NULL DEFAULT '',
+ADD COLUMN claimed_at TIMESTAMP,
+ADD COLUMN processed_at TIMESTAMP;
+
+CREATE INDEX media_processing_idx ON media (created_at) WHERE status = 'processing';
+
+CREATE TABLE media_renditions(
+  media_id UUID NOT NULL REFERENCES media(id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  blob_key TEXT NOT NULL,
+  content_type TEXT NOT NULL,
+  width INTEGER NOT NULL,
+  height INTEGER NOT NULL,
+  size_bytes BIGINT NOT NULL,
+  PRIMARY KEY (media_id, name)
+);
+
+CREATE INDEX media_renditions_blob_key_idx ON media_renditions (blob_key);
+
+-- media uploaded before this was served as is from a shared media/ blob.
+-- The default leaves it processing, so the workers strip its metadata
+-- like any upload's. Processing moves an avatar_url off the old blob, and
+-- the old blob stays until no unprocessed media shares it.
+
+-- +goose Down
+DROP TABLE media_renditions;
+
+ALTER TABLE media
+DROP COLUMN processed_at,
+DROP COLUMN claimed_at,
+DROP COLUMN error,
+DROP COLUMN height,
+DROP COLUMN width,
+DROP COLUMN status;
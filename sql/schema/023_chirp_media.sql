@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE chirp_media(
+  chirp_id UUID NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+  media_id UUID NOT NULL REFERENCES media(id) ON DELETE CASCADE,
+  position SMALLINT NOT NULL CHECK (position BETWEEN 0 AND 3),
+  alt_text TEXT NOT NULL DEFAULT '',
+  PRIMARY KEY (chirp_id, position),
+  UNIQUE (chirp_id, media_id)
+);
+
+CREATE INDEX chirp_media_media_id_idx ON chirp_media (media_id);
+
+-- +goose Down
+DROP TABLE chirp_media;
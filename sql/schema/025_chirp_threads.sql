@@ -0,0 +1,18 @@
+-- +goose Up
+-- in_reply_to is the chirp being replied to and root_id the one that
+-- started the thread; both are NULL for chirps that start a thread.
+-- Chirps with replies are tombstoned instead of deleted, so the links only
+-- go away along with a whole account.
+ALTER TABLE chirps
+  ADD COLUMN in_reply_to UUID REFERENCES chirps(id) ON DELETE SET NULL,
+  ADD COLUMN root_id UUID REFERENCES chirps(id) ON DELETE SET NULL,
+  ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX chirps_in_reply_to_idx ON chirps (in_reply_to, created_at);
+CREATE INDEX chirps_root_id_idx ON chirps (root_id);
+
+-- +goose Down
+ALTER TABLE chirps
+  DROP COLUMN deleted_at,
+  DROP COLUMN root_id,
+  DROP COLUMN in_reply_to;
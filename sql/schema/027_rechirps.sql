@@ -0,0 +1,20 @@
+-- +goose Up
+-- a rechirp shares rechirp_of as it is, with no body of its own; a quote
+-- has a body and shows quote_of below it. Quoted chirps are tombstoned
+-- instead of deleted, like those with replies, so quotes can tell their
+-- original is gone.
+ALTER TABLE chirps
+  ADD COLUMN rechirp_of UUID REFERENCES chirps(id) ON DELETE CASCADE,
+  ADD COLUMN quote_of UUID REFERENCES chirps(id) ON DELETE SET NULL,
+  ADD CONSTRAINT chirps_rechirp_check
+    CHECK (rechirp_of IS NULL OR (body = '' AND quote_of IS NULL AND in_reply_to IS NULL));
+
+-- rechirping the same chirp twice makes no sense
+CREATE UNIQUE INDEX chirps_rechirp_of_idx ON chirps (rechirp_of, user_id) WHERE rechirp_of IS NOT NULL;
+CREATE INDEX chirps_quote_of_idx ON chirps (quote_of);
+
+-- +goose Down
+ALTER TABLE chirps
+  DROP CONSTRAINT chirps_rechirp_check,
+  DROP COLUMN quote_of,
+  DROP COLUMN rechirp_of;
@@ -0,0 +1,47 @@
+-- +goose Up
+CREATE TABLE follows(
+  follower_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  followee_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  created_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (follower_id, followee_id),
+  CHECK (follower_id <> followee_id)
+);
+
+CREATE INDEX follows_follower_id_idx ON follows (follower_id, created_at, followee_id);
+CREATE INDEX follows_followee_id_idx ON follows (followee_id, created_at, follower_id);
+
+-- kept up to date along with follows
+ALTER TABLE users
+  ADD COLUMN follower_count INTEGER NOT NULL DEFAULT 0,
+  ADD COLUMN following_count INTEGER NOT NULL DEFAULT 0;
+
+-- chirps by accounts with few enough followers are copied into each
+-- follower's timeline when posted; the rest are looked up when a timeline
+-- is read. fanned_out records which way a chirp went, so it stays in
+-- timelines when its author's follower count moves past the limit.
+ALTER TABLE chirps ADD COLUMN fanned_out BOOLEAN NOT NULL DEFAULT false;
+
+CREATE INDEX chirps_user_id_idx ON chirps (user_id, created_at);
+CREATE INDEX chirps_pulled_idx ON chirps (user_id, created_at) WHERE NOT fanned_out;
+
+CREATE TABLE timeline_entries(
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  chirp_id UUID NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+  author_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  created_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (user_id, chirp_id)
+);
+
+CREATE INDEX timeline_entries_user_id_idx ON timeline_entries (user_id, created_at, chirp_id);
+CREATE INDEX timeline_entries_author_id_idx ON timeline_entries (user_id, author_id);
+CREATE INDEX timeline_entries_chirp_id_idx ON timeline_entries (chirp_id);
+
+-- +goose Down
+DROP TABLE timeline_entries;
+DROP INDEX chirps_pulled_idx;
+DROP INDEX chirps_user_id_idx;
+ALTER TABLE chirps DROP COLUMN fanned_out;
+ALTER TABLE users
+  DROP COLUMN following_count,
+  DROP COLUMN follower_count;
+DROP TABLE follows;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- media is only collected once no avatar_url points at one of its
+-- renditions
+CREATE INDEX users_avatar_url_idx ON users (avatar_url);
+
+-- +goose Down
+DROP INDEX users_avatar_url_idx;
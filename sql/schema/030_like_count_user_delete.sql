@@ -0,0 +1,22 @@
+-- +goose Up
+-- a deleted user's likes go with it through ON DELETE CASCADE, which
+-- doesn't touch like_count. UnlikeChirp keeps the count itself, so a like
+-- deleted while its user still exists is left alone.
+-- +goose StatementBegin
+CREATE FUNCTION uncount_deleted_users_like() RETURNS trigger AS $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM users WHERE id = OLD.user_id) THEN
+    UPDATE chirps SET like_count = like_count - 1 WHERE id = OLD.chirp_id;
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER chirp_likes_user_deleted
+AFTER DELETE ON chirp_likes
+FOR EACH ROW EXECUTE FUNCTION uncount_deleted_users_like();
+
+-- +goose Down
+DROP TRIGGER chirp_likes_user_deleted ON chirp_likes;
+DROP FUNCTION uncount_deleted_users_like();
@@ -0,0 +1,25 @@
+-- +goose Up
+-- like likes, a deleted user's follows go with it through ON DELETE
+-- CASCADE and have to come off the other side's counts here.
+-- UnfollowUser keeps the counts itself while both users exist.
+-- +goose StatementBegin
+CREATE FUNCTION uncount_deleted_users_follow() RETURNS trigger AS $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM users WHERE id = OLD.follower_id) THEN
+    UPDATE users SET follower_count = follower_count - 1 WHERE id = OLD.followee_id;
+  END IF;
+  IF NOT EXISTS (SELECT 1 FROM users WHERE id = OLD.followee_id) THEN
+    UPDATE users SET following_count = following_count - 1 WHERE id = OLD.follower_id;
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER follows_user_deleted
+AFTER DELETE ON follows
+FOR EACH ROW EXECUTE FUNCTION uncount_deleted_users_follow();
+
+-- +goose Down
+DROP TRIGGER follows_user_deleted ON follows;
+DROP FUNCTION uncount_deleted_users_follow();
@@ -0,0 +1,13 @@
+-- +goose Up
+-- chirps waiting for the fan-out workers. Until a worker gets to one it
+-- isn't fanned_out, so timelines look it up like a big account's chirp.
+CREATE TABLE fanout_queue(
+  chirp_id UUID PRIMARY KEY REFERENCES chirps(id) ON DELETE CASCADE,
+  created_at TIMESTAMP NOT NULL,
+  claimed_at TIMESTAMP
+);
+
+CREATE INDEX fanout_queue_created_at_idx ON fanout_queue (created_at);
+
+-- +goose Down
+DROP TABLE fanout_queue;
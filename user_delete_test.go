@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/circuit-shell/http-server-go/internal/database"
+	_ "github.com/lib/pq"
+)
+
+// testDB migrates a schema of its own in the database at TEST_DB_URL and
+// drops it when the test is done. Without TEST_DB_URL the test is skipped.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dbURL := os.Getenv("TEST_DB_URL")
+	if dbURL == "" {
+		t.Skip("TEST_DB_URL is not set")
+	}
+	ctx := context.Background()
+
+	b := make([]byte, 6)
+	_, err := rand.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := "test_" + hex.EncodeToString(b)
+
+	admin, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	_, err = admin.ExecContext(ctx, "CREATE SCHEMA "+schema)
+	if err != nil {
+		t.Fatalf("creating schema: %v", err)
+	}
+	t.Cleanup(func() {
+		_, err := admin.ExecContext(ctx, "DROP SCHEMA "+schema+" CASCADE")
+		if err != nil {
+			t.Errorf("dropping schema: %v", err)
+		}
+	})
+
+	separator := "?"
+	if strings.Contains(dbURL, "?") {
+		separator = "&"
+	}
+	db, err := sql.Open("postgres", dbURL+separator+"search_path="+schema)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrations, err := filepath.Glob("sql/schema/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, migration := range migrations {
+		data, err := os.ReadFile(migration)
+		if err != nil {
+			t.Fatal(err)
+		}
+		up, _, _ := strings.Cut(string(data), "-- +goose Down")
+		_, err = db.ExecContext(ctx, up)
+		if err != nil {
+			t.Fatalf("applying %s: %v", migration, err)
+		}
+	}
+	return db
+}
+
+func createTestUser(t *testing.T, q *database.Queries, handle string) database.User {
+	t.Helper()
+	user, err := q.CreateUser(context.Background(), database.CreateUserParams{
+		Email:  handle + "@example.com",
+		Handle: handle,
+	})
+	if err != nil {
+		t.Fatalf("creating user %s: %v", handle, err)
+	}
+	return user
+}
+
+func TestDeletingUserUpdatesCounts(t *testing.T) {
+	db := testDB(t)
+	q := database.New(db)
+	ctx := context.Background()
+
+	author := createTestUser(t, q, "author")
+	deleted := createTestUser(t, q, "deleted")
+	chirp, err := q.CreateChirp(ctx, database.CreateChirpParams{Body: "hello", UserID: author.ID})
+	if err != nil {
+		t.Fatalf("creating chirp: %v", err)
+	}
+	for _, user := range []database.User{author, deleted} {
+		err = q.LikeChirp(ctx, database.LikeChirpParams{UserID: user.ID, ChirpID: chirp.ID})
+		if err != nil {
+			t.Fatalf("liking chirp: %v", err)
+		}
+	}
+
+	fan := createTestUser(t, q, "fan")
+	follows := [][2]database.User{{fan, author}, {deleted, author}, {author, deleted}}
+	for _, follow := range follows {
+		_, err = q.FollowUser(ctx, database.FollowUserParams{FollowerID: follow[0].ID, FolloweeID: follow[1].ID})
+		if err != nil {
+			t.Fatalf("following: %v", err)
+		}
+		err = q.AdjustFollowCounts(ctx, database.AdjustFollowCountsParams{
+			FollowerID: follow[0].ID,
+			FolloweeID: follow[1].ID,
+			Delta:      1,
+		})
+		if err != nil {
+			t.Fatalf("counting follow: %v", err)
+		}
+	}
+
+	_, err = db.ExecContext(ctx, "DELETE FROM users WHERE id = $1", deleted.ID)
+	if err != nil {
+		t.Fatalf("deleting user: %v", err)
+	}
+
+	chirp, err = q.GetChirpsByID(ctx, chirp.ID)
+	if err != nil {
+		t.Fatalf("getting chirp: %v", err)
+	}
+	if chirp.LikeCount != 1 {
+		t.Errorf("like_count = %d, want 1", chirp.LikeCount)
+	}
+
+	wantCounts := []struct {
+		user          database.User
+		wantFollowers int32
+		wantFollowing int32
+	}{
+		{user: author, wantFollowers: 1, wantFollowing: 0},
+		{user: fan, wantFollowers: 0, wantFollowing: 1},
+	}
+	for _, tt := range wantCounts {
+		user, err := q.GetUserByID(ctx, tt.user.ID)
+		if err != nil {
+			t.Fatalf("getting user: %v", err)
+		}
+		if user.FollowerCount != tt.wantFollowers || user.FollowingCount != tt.wantFollowing {
+			t.Errorf("%s: follower_count = %d, following_count = %d, want %d and %d",
+				user.Handle, user.FollowerCount, user.FollowingCount, tt.wantFollowers, tt.wantFollowing)
+		}
+	}
+}